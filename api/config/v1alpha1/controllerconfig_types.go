@@ -0,0 +1,116 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	DefaultMaxConcurrentReconciles = 1
+	DefaultImage                   = "nginx:latest"
+	DefaultWebhookPort             = 9443
+	DefaultMetricsBindAddress      = ":8080"
+	DefaultHealthProbeBindAddress  = ":8081"
+)
+
+//+kubebuilder:object:root=true
+
+// ControllerConfig is the Schema for the controller configuration file (--config)
+// ファイルを変更した場合はdefaultImageのみ再起動せずに反映し、それ以外は再起動するまで反映しない
+type ControllerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// 全てのNginxをReconcileする間隔(未指定の場合はcontroller-runtimeのデフォルトの10時間)
+	// +optional
+	SyncPeriod *metav1.Duration `json:"syncPeriod,omitempty"`
+
+	// 同時にReconcileするNginxの数
+	// +optional
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+
+	// NginxClassにもConfigMapにもspecにも指定されていない場合のImage
+	// +optional
+	DefaultImage string `json:"defaultImage,omitempty"`
+
+	// Watch対象のNamespace(watchNamespaceSelectorとは同時に指定できない、どちらも未指定の場合は全Namespace)
+	// +optional
+	WatchNamespaces []string `json:"watchNamespaces,omitempty"`
+
+	// Watch対象のNamespaceのLabel Selector(起動時に一致したNamespaceのみ対象にする)
+	// +optional
+	WatchNamespaceSelector string `json:"watchNamespaceSelector,omitempty"`
+
+	// +optional
+	Webhook WebhookConfig `json:"webhook,omitempty"`
+
+	// +optional
+	Metrics MetricsConfig `json:"metrics,omitempty"`
+
+	// +optional
+	Health HealthConfig `json:"health,omitempty"`
+
+	// 有効/無効にするFeature Gate
+	// +optional
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+}
+
+// WebhookConfig defines the webhook server
+type WebhookConfig struct {
+	// +optional
+	Port int `json:"port,omitempty"`
+
+	// 証明書を配置するディレクトリ(未指定の場合はcontroller-runtimeのデフォルト)
+	// +optional
+	CertDir string `json:"certDir,omitempty"`
+}
+
+// MetricsConfig defines the metrics endpoint
+type MetricsConfig struct {
+	// "0"の場合はmetricsを無効にする
+	// +optional
+	BindAddress string `json:"bindAddress,omitempty"`
+}
+
+// HealthConfig defines the health probe endpoint
+type HealthConfig struct {
+	// +optional
+	HealthProbeBindAddress string `json:"healthProbeBindAddress,omitempty"`
+}
+
+// Default は未指定の項目にデフォルト値を設定する
+func (c *ControllerConfig) Default() {
+	if c.MaxConcurrentReconciles == 0 {
+		c.MaxConcurrentReconciles = DefaultMaxConcurrentReconciles
+	}
+	if c.DefaultImage == "" {
+		c.DefaultImage = DefaultImage
+	}
+	if c.Webhook.Port == 0 {
+		c.Webhook.Port = DefaultWebhookPort
+	}
+	if c.Metrics.BindAddress == "" {
+		c.Metrics.BindAddress = DefaultMetricsBindAddress
+	}
+	if c.Health.HealthProbeBindAddress == "" {
+		c.Health.HealthProbeBindAddress = DefaultHealthProbeBindAddress
+	}
+}
+
+func init() {
+	SchemeBuilder.Register(&ControllerConfig{})
+}
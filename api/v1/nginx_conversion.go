@@ -0,0 +1,180 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// v1で表現できないv2のspecを保持するAnnotation
+// v2→v1→v2と変換した場合にv2にしか存在しないフィールドが失われないようにする
+const ConversionDataAnnotation = "nginx.my.domain/v2-spec"
+
+var _ conversion.Convertible = &Nginx{}
+
+// ConvertTo converts this Nginx to the Hub version (v2).
+func (src *Nginx) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*nginxv2.Nginx)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	// v2にしか存在しないフィールドをAnnotationから復元し、v1のフィールドで上書きする
+	if data, ok := dst.Annotations[ConversionDataAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &dst.Spec); err != nil {
+			return err
+		}
+		delete(dst.Annotations, ConversionDataAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	src.Spec.convertTo(&dst.Spec)
+	src.Status.convertTo(&dst.Status)
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v2) to this version.
+func (dst *Nginx) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*nginxv2.Nginx)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec.convertFrom(&src.Spec)
+	dst.Status.convertFrom(&src.Status)
+
+	// v1に変換すると失われるフィールドがある場合はv2のspecをAnnotationに保持する
+	var restored nginxv2.NginxSpec
+	dst.Spec.convertTo(&restored)
+	if !equality.Semantic.DeepEqual(restored, src.Spec) {
+		data, err := json.Marshal(src.Spec)
+		if err != nil {
+			return err
+		}
+		if dst.Annotations == nil {
+			dst.Annotations = make(map[string]string)
+		}
+		dst.Annotations[ConversionDataAnnotation] = string(data)
+	}
+
+	return nil
+}
+
+// v1のspecをv2のspecに変換する(v2にしか存在しないフィールドはそのまま残す)
+func (src *NginxSpec) convertTo(dst *nginxv2.NginxSpec) {
+	dst.Replicas = src.Replicas
+	dst.Image = src.Image
+	dst.Service.Type = src.ServiceType
+	dst.Strategy = nginxv2.NginxStrategy{
+		Type:      nginxv2.NginxStrategyType(src.Strategy.Type),
+		BlueGreen: (*nginxv2.BlueGreenStrategy)(src.Strategy.BlueGreen.DeepCopy()),
+	}
+	dst.Rollout = (*nginxv2.RolloutSpec)(src.Rollout.DeepCopy())
+	dst.SpecHistoryLimit = src.SpecHistoryLimit
+	dst.RollbackTo = src.RollbackTo
+	dst.Config = nil
+	if src.Config != nil {
+		dst.Config = &nginxv2.NginxConfig{
+			Content:        src.Config.Content,
+			ReloadStrategy: nginxv2.ReloadStrategy(src.Config.ReloadStrategy),
+		}
+	}
+}
+
+// v2のspecをv1のspecに変換する
+func (dst *NginxSpec) convertFrom(src *nginxv2.NginxSpec) {
+	dst.Replicas = src.Replicas
+	dst.Image = src.Image
+	dst.ServiceType = src.Service.Type
+	dst.Strategy = NginxStrategy{
+		Type:      NginxStrategyType(src.Strategy.Type),
+		BlueGreen: (*BlueGreenStrategy)(src.Strategy.BlueGreen.DeepCopy()),
+	}
+	dst.Rollout = (*RolloutSpec)(src.Rollout.DeepCopy())
+	dst.SpecHistoryLimit = src.SpecHistoryLimit
+	dst.RollbackTo = src.RollbackTo
+	dst.Config = nil
+	if src.Config != nil {
+		dst.Config = &NginxConfig{
+			Content:        src.Config.Content,
+			ReloadStrategy: ReloadStrategy(src.Config.ReloadStrategy),
+		}
+	}
+}
+
+// v1のstatusをv2のstatusに変換する
+func (src *NginxStatus) convertTo(dst *nginxv2.NginxStatus) {
+	dst.DeploymentName = src.DeploymentName
+	dst.AvailableReplicas = src.AvailableReplicas
+	dst.ServiceName = src.ServiceName
+	dst.ClusterIP = src.ClusterIP
+	dst.ExternalIP = src.ExternalIP
+	dst.ActiveColor = src.ActiveColor
+	dst.PreviewServiceName = src.PreviewServiceName
+	dst.CurrentRevision = src.CurrentRevision
+	dst.Revisions = nil
+	for _, revision := range src.Revisions {
+		dst.Revisions = append(dst.Revisions, nginxv2.SpecRevision(revision))
+	}
+	dst.ConfigHash = src.ConfigHash
+	dst.Pods = nil
+	if src.Pods != nil {
+		dst.Pods = &nginxv2.PodsStatus{
+			Total:    src.Pods.Total,
+			Ready:    src.Pods.Ready,
+			UpToDate: src.Pods.UpToDate,
+		}
+		for _, pod := range src.Pods.UnhealthyPods {
+			dst.Pods.UnhealthyPods = append(dst.Pods.UnhealthyPods, nginxv2.PodStatus(*pod.DeepCopy()))
+		}
+	}
+	dst.Conditions = src.Conditions
+}
+
+// v2のstatusをv1のstatusに変換する
+func (dst *NginxStatus) convertFrom(src *nginxv2.NginxStatus) {
+	dst.DeploymentName = src.DeploymentName
+	dst.AvailableReplicas = src.AvailableReplicas
+	dst.ServiceName = src.ServiceName
+	dst.ClusterIP = src.ClusterIP
+	dst.ExternalIP = src.ExternalIP
+	dst.ActiveColor = src.ActiveColor
+	dst.PreviewServiceName = src.PreviewServiceName
+	dst.CurrentRevision = src.CurrentRevision
+	dst.Revisions = nil
+	for _, revision := range src.Revisions {
+		dst.Revisions = append(dst.Revisions, SpecRevision(revision))
+	}
+	dst.ConfigHash = src.ConfigHash
+	dst.Pods = nil
+	if src.Pods != nil {
+		dst.Pods = &PodsStatus{
+			Total:    src.Pods.Total,
+			Ready:    src.Pods.Ready,
+			UpToDate: src.Pods.UpToDate,
+		}
+		for _, pod := range src.Pods.UnhealthyPods {
+			dst.Pods.UnhealthyPods = append(dst.Pods.UnhealthyPods, PodStatus(*pod.DeepCopy()))
+		}
+	}
+	dst.Conditions = src.Conditions
+}
@@ -0,0 +1,65 @@
+package v1
+
+import (
+	nginxv2 "example.com/nginx-controller/api/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("Nginx Conversion", func() {
+
+	replicas := int32(3)
+	delay := int32(10)
+	maxSurge := intstr.FromString("50%")
+
+	newV1 := func() *Nginx {
+		return &Nginx{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx-1", Namespace: "default"},
+			Spec: NginxSpec{
+				Replicas:    &replicas,
+				ServiceType: corev1.ServiceTypeNodePort,
+				Image:       "nginx:1.23",
+				Strategy: NginxStrategy{
+					Type:      BlueGreenStrategyType,
+					BlueGreen: &BlueGreenStrategy{AutoPromotionEnabled: true, ScaleDownDelaySeconds: &delay},
+				},
+				Rollout: &RolloutSpec{MaxSurge: &maxSurge},
+				Config:  &NginxConfig{Content: "server {}", ReloadStrategy: HotReloadReloadStrategy},
+			},
+			Status: NginxStatus{
+				DeploymentName: "deploy-nginx-1",
+				ActiveColor:    "blue",
+				Pods: &PodsStatus{
+					Total:         1,
+					UnhealthyPods: []PodStatus{{Name: "pod-1", Restarts: 2}},
+				},
+			},
+		}
+	}
+
+	// v1→v2の変換のテスト
+	It("Should convert v1 to v2", func() {
+		v2 := &nginxv2.Nginx{}
+		Expect(newV1().ConvertTo(v2)).To(Succeed())
+
+		Expect(v2.Name).To(Equal("nginx-1"))
+		Expect(v2.Spec.Service.Type).To(Equal(corev1.ServiceTypeNodePort))
+		Expect(v2.Spec.Strategy.Type).To(Equal(nginxv2.BlueGreenStrategyType))
+		Expect(*v2.Spec.Strategy.BlueGreen.ScaleDownDelaySeconds).To(Equal(delay))
+		Expect(v2.Spec.Config.ReloadStrategy).To(Equal(nginxv2.HotReloadReloadStrategy))
+		Expect(v2.Status.Pods.UnhealthyPods[0].Restarts).To(Equal(int32(2)))
+	})
+
+	// v1→v2→v1の変換で内容が変わらないことのテスト
+	It("Should round-trip v1 through v2", func() {
+		v2 := &nginxv2.Nginx{}
+		Expect(newV1().ConvertTo(v2)).To(Succeed())
+
+		v1 := &Nginx{}
+		Expect(v1.ConvertFrom(v2)).To(Succeed())
+		Expect(v1).To(Equal(newV1()))
+	})
+})
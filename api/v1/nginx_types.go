@@ -19,11 +19,21 @@ package v1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// BlueGreenの場合にpreviewのcolorへの切り替えを指示するAnnotation("true"でpromote)
+	// previewの全てのPodがAvailableになってから切り替え、展開中のpreviewがない場合は削除される
+	PromoteAnnotation = "nginx.my.domain/promote"
+
+	// spec.rollbackToの代わりにrollback先のrevisionを指定するAnnotation
+	RollbackToAnnotation = "nginx.my.domain/rollback-to"
 )
 
 // NginxSpec defines the desired state of Nginx
 type NginxSpec struct {
-	// +kubebuilder:default = 1
+	// 未指定の場合はMutation Webhookがデフォルト値を設定する
 	// +kubebuilder:validation:Minimum = 0
 
 	Replicas *int32 `json:"replicas,omitempty"`
@@ -31,6 +41,112 @@ type NginxSpec struct {
 	// +kubebuilder:default = ClusterIP
 
 	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+
+	// nginxコンテナのImage(未指定の場合はMutation Webhookがデフォルト値を設定する)
+	Image string `json:"image,omitempty"`
+
+	// Podの更新方式
+	// +optional
+	Strategy NginxStrategy `json:"strategy,omitempty"`
+
+	// 管理するDeploymentのRollingUpdateに関する設定
+	// +optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
+
+	// ControllerRevisionとして保持するReadyになったspecの数
+	// +kubebuilder:default=5
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	SpecHistoryLimit *int32 `json:"specHistoryLimit,omitempty"`
+
+	// 指定したrevisionのspecにrollbackする(rollback後にControllerが削除する)
+	// +optional
+	RollbackTo *int64 `json:"rollbackTo,omitempty"`
+
+	// nginxの設定(未指定の場合はImageのデフォルト設定を使用する)
+	// +optional
+	Config *NginxConfig `json:"config,omitempty"`
+}
+
+// NginxConfig defines the nginx configuration rendered into the managed ConfigMap
+type NginxConfig struct {
+	// /etc/nginx/conf.d/default.confとして配置する設定(httpコンテキスト)
+	// 反映前に"nginx -t"で検証され、エラーの場合はPodに反映されない
+	Content string `json:"content"`
+
+	// 設定の変更をPodに反映する方法
+	// +kubebuilder:default=Restart
+	// +optional
+	ReloadStrategy ReloadStrategy `json:"reloadStrategy,omitempty"`
+}
+
+// ReloadStrategy は設定の変更をPodに反映する方法
+// +kubebuilder:validation:Enum=Restart;HotReload
+type ReloadStrategy string
+
+const (
+	// Podを再作成して設定を反映する
+	RestartReloadStrategy ReloadStrategy = "Restart"
+	// sidecarが"nginx -t"で検証してからnginxのmaster processにSIGHUPを送って設定を反映する(Podは再作成しない)
+	HotReloadReloadStrategy ReloadStrategy = "HotReload"
+)
+
+// RolloutSpec defines the rollout settings of the managed Deployment
+type RolloutSpec struct {
+	// RollingUpdate中に追加で作成できるPodの数(または割合)
+	// +optional
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+
+	// RollingUpdate中にUnavailableになってもよいPodの数(または割合)
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+
+	// PodがReadyになってからAvailableとみなされるまでの秒数
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinReadySeconds *int32 `json:"minReadySeconds,omitempty"`
+
+	// Rolloutが進まない場合にProgressDeadlineExceededとみなすまでの秒数
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+
+	// 保持する古いReplicaSetの数
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+}
+
+// NginxStrategyType はPodの更新方式の種類
+// +kubebuilder:validation:Enum=RollingUpdate;BlueGreen
+type NginxStrategyType string
+
+const (
+	// 1つのDeploymentをRollingUpdateで更新する
+	RollingUpdateStrategyType NginxStrategyType = "RollingUpdate"
+	// blue/greenの2つのDeploymentを切り替えて更新する
+	BlueGreenStrategyType NginxStrategyType = "BlueGreen"
+)
+
+// NginxStrategy defines how the pods of Nginx are updated
+type NginxStrategy struct {
+	// +kubebuilder:default=RollingUpdate
+	Type NginxStrategyType `json:"type,omitempty"`
+
+	// Typeが"BlueGreen"の場合の設定
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+}
+
+// BlueGreenStrategy defines the behavior of the blue/green deployment
+type BlueGreenStrategy struct {
+	// trueの場合、previewのDeploymentが全てAvailableになった時点で自動的にpromoteする
+	// falseの場合は"nginx.my.domain/promote"Annotationが付与されるまでpromoteしない
+	AutoPromotionEnabled bool `json:"autoPromotionEnabled,omitempty"`
+
+	// promote後に旧colorのDeploymentをscale downするまでの秒数
+	// +kubebuilder:default=30
+	// +kubebuilder:validation:Minimum=0
+	ScaleDownDelaySeconds *int32 `json:"scaleDownDelaySeconds,omitempty"`
 }
 
 // NginxStatus defines the observed state of Nginx
@@ -42,8 +158,96 @@ type NginxStatus struct {
 	ClusterIP string `json:"clusterIP,omitempty"`
 
 	ExternalIP string `json:"externalIP,omitempty"`
+
+	// BlueGreenの場合にServiceがトラフィックを流しているcolor(blue/green)
+	ActiveColor string `json:"activeColor,omitempty"`
+
+	// BlueGreenの場合にpreview用のcolorを公開するService
+	PreviewServiceName string `json:"previewServiceName,omitempty"`
+
+	// 現在のspecに対応するrevision(Readyになったことがない場合は0)
+	// +optional
+	CurrentRevision int64 `json:"currentRevision,omitempty"`
+
+	// rollbackできるspecのrevision一覧
+	// +optional
+	Revisions []SpecRevision `json:"revisions,omitempty"`
+
+	// spec.config.contentのハッシュ値(md5)
+	// +optional
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// 管理しているPodの集計とReadyでない、または古いImage/設定で動いているPod
+	// +optional
+	Pods *PodsStatus `json:"pods,omitempty"`
+
+	// Nginxの状態を表すCondition
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// SpecRevision describes a ready spec of Nginx stored in a ControllerRevision
+type SpecRevision struct {
+	Revision int64 `json:"revision"`
+
+	// specを保存しているControllerRevisionの名前
+	Name string `json:"name"`
+
+	Image string `json:"image,omitempty"`
+
+	CreationTimestamp metav1.Time `json:"creationTimestamp"`
+}
+
+// PodsStatus summarizes the pods managed by Nginx
+type PodsStatus struct {
+	Total int32 `json:"total"`
+	Ready int32 `json:"ready"`
+
+	// 最新のImageと設定で動いているPodの数
+	UpToDate int32 `json:"upToDate"`
+
+	// ReadyでないかUpToDateでないPod(Pod数が多い場合に備えて先頭の一部のみ)
+	// +optional
+	UnhealthyPods []PodStatus `json:"unhealthyPods,omitempty"`
+}
+
+// PodStatus describes a pod managed by Nginx
+type PodStatus struct {
+	Name string `json:"name"`
+
+	// +optional
+	Node string `json:"node,omitempty"`
+
+	Ready bool `json:"ready"`
+
+	// Pod内の全てのコンテナの再起動回数の合計
+	Restarts int32 `json:"restarts"`
+
+	// nginxコンテナのImage
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Podのnginxが読み込んでいる設定のハッシュ値(md5)
+	// +optional
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// HotReloadの場合に最後に設定を読み込んだ時刻
+	// +optional
+	LastReloadTime *metav1.Time `json:"lastReloadTime,omitempty"`
 }
 
+const (
+	// 管理するDeploymentのRolloutがprogressDeadlineSeconds以内に完了しなかったことを表すCondition
+	ConditionDegraded = "Degraded"
+
+	// spec.configが"nginx -t"による検証に成功したかを表すCondition
+	ConditionConfigValid = "ConfigValid"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Namespaced
@@ -52,6 +256,8 @@ type NginxStatus struct {
 // +kubebuilder:printcolumn:JSONPath=".status.serviceName",name=Service_Name,type=string
 // +kubebuilder:printcolumn:JSONPath=".status.clusterIP",name=Cluster-IP,type=string
 // +kubebuilder:printcolumn:JSONPath=".status.externalIP",name=External-IP,type=string
+// +kubebuilder:printcolumn:JSONPath=".status.pods.upToDate",name=Up-To-Date,type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.activeColor",name=Active_Color,type=string
 
 // Nginx is the Schema for the nginxes API
 type Nginx struct {
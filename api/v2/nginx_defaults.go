@@ -0,0 +1,322 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// クラスタ全体のspecのデフォルト値を保持するConfigMapの名前とKey
+	// Keyの値はNginxSpecの一部をYAMLで記述する(replicas/image/port/service/resources/readinessProbe/livenessProbe)
+	DefaultsConfigMapName = "nginx-defaults"
+	DefaultsConfigMapKey  = "defaults.yaml"
+
+	// ConfigMapにもspecにも指定されていない場合のデフォルト値
+	DefaultImage = "nginx:latest"
+	DefaultPort  = int32(80)
+
+	// nginxコンテナのPortの名前(Probeから参照する)
+	PortName = "http"
+
+	// spec.securityContext.hardenedの場合のデフォルト値(非rootで動作し、1024未満のPortはListenできない)
+	HardenedImage = "nginxinc/nginx-unprivileged:latest"
+	HardenedPort  = int32(8080)
+)
+
+// DefaultsConfigMapNameのConfigMapを参照するNamespace(空の場合はConfigMapを参照しない)
+var DefaultsNamespace string
+
+// 組み込みのデフォルト値のImage(ControllerConfigのdefaultImageで変更され、設定ファイルの変更時に再読み込みされる)
+var builtinImage atomic.Value
+
+// SetBuiltinImage は組み込みのデフォルト値のImageを変更する(空の場合はDefaultImageに戻す)
+func SetBuiltinImage(image string) {
+	builtinImage.Store(image)
+}
+
+// BuiltinImage はNginxClassにもConfigMapにもspecにも指定されていない場合のImageを返す
+func BuiltinImage() string {
+	if image, _ := builtinImage.Load().(string); image != "" {
+		return image
+	}
+	return DefaultImage
+}
+
+// Mutation
+// ConfigMapのデフォルト値を参照するためClientを持つCustomDefaulterとして実装する
+type nginxDefaulter struct {
+	reader    client.Reader
+	namespace string
+}
+
+var _ admission.CustomDefaulter = &nginxDefaulter{}
+
+// Default implements admission.CustomDefaulter so a webhook will be registered for the type
+func (d *nginxDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*Nginx)
+	if !ok {
+		return fmt.Errorf("expected a Nginx but got a %T", obj)
+	}
+
+	nginxlog.Info("[Mutation] Start Mutation", "name", r.Name)
+	nginxlog.Info("[Mutation] Add Annotations: nginx: "+r.Name, "name", r.Name)
+
+	// Nginxリソース作成時にAnnotationsを付与する(既存のAnnotationsは残す)
+	if r.ObjectMeta.Annotations == nil {
+		r.ObjectMeta.Annotations = make(map[string]string)
+	}
+	r.ObjectMeta.Annotations["nginx"] = r.Name
+
+	// 更新でhardenedを有効にした場合は、作成時に保存された組み込みのデフォルト値を置き換える
+	old, err := oldNginx(ctx)
+	if err != nil {
+		nginxlog.Error(err, "[Mutation] Unable to decode the old Nginx", "name", r.Name)
+		return err
+	}
+	if old != nil {
+		r.Spec.clearBuiltinDefaultsForHardened(&old.Spec)
+	}
+
+	// hardenedの場合は非rootで動作するImageとPortを優先する
+	r.Spec.applyHardenedDefaults()
+
+	// NginxClass > ConfigMap > 組み込みのデフォルト値の順に優先する
+	class, err := d.nginxClass(ctx, r)
+	if err != nil {
+		nginxlog.Error(err, "[Mutation] Unable to resolve NginxClass", "name", r.Name)
+		return err
+	}
+	if class != nil {
+		r.Spec.NginxClassName = class.Name
+		r.Spec.applyDefaults(class.Spec.Defaults.nginxSpec())
+	}
+
+	defaults, err := d.clusterDefaults(ctx)
+	if err != nil {
+		nginxlog.Error(err, "[Mutation] Unable to load cluster defaults", "name", r.Name)
+		return err
+	}
+	r.Spec.applyDefaults(defaults)
+	r.Spec.applyDefaults(builtinDefaults())
+
+	return nil
+}
+
+// 更新時の変更前のNginxを返す(作成時はnil)
+func oldNginx(ctx context.Context) (*Nginx, error) {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil || len(req.OldObject.Raw) == 0 {
+		return nil, nil
+	}
+	old := &Nginx{}
+	if err := yaml.Unmarshal(req.OldObject.Raw, old); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// spec.nginxClassNameのNginxClassを取得する
+// 未指定の場合はデフォルトのNginxClassを返す(存在しない場合はnil)
+// 指定したNginxClassが存在しない場合はValidation Webhookでエラーにするためnilを返す
+func (d *nginxDefaulter) nginxClass(ctx context.Context, r *Nginx) (*NginxClass, error) {
+	if r.Spec.NginxClassName != "" {
+		var class NginxClass
+		if err := d.reader.Get(ctx, client.ObjectKey{Name: r.Spec.NginxClassName}, &class); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		return &class, nil
+	}
+
+	return defaultNginxClass(ctx, d.reader)
+}
+
+// デフォルトのAnnotationが付与されたNginxClassを取得する(存在しない場合はnil)
+// 複数ある場合は名前順で最初のものを使用する
+func defaultNginxClass(ctx context.Context, reader client.Reader) (*NginxClass, error) {
+	var classList NginxClassList
+	if err := reader.List(ctx, &classList); err != nil {
+		return nil, err
+	}
+
+	var class *NginxClass
+	for i := range classList.Items {
+		if classList.Items[i].Annotations[DefaultClassAnnotation] != "true" {
+			continue
+		}
+		if class == nil || classList.Items[i].Name < class.Name {
+			class = &classList.Items[i]
+		}
+	}
+
+	return class, nil
+}
+
+// ConfigMapからクラスタ全体のデフォルト値を読み込む(ConfigMapが存在しない場合は空)
+func (d *nginxDefaulter) clusterDefaults(ctx context.Context) (*NginxSpec, error) {
+	defaults := &NginxSpec{}
+	if d.namespace == "" {
+		return defaults, nil
+	}
+
+	var cm corev1.ConfigMap
+	if err := d.reader.Get(ctx, client.ObjectKey{Namespace: d.namespace, Name: DefaultsConfigMapName}, &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return defaults, nil
+		}
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal([]byte(cm.Data[DefaultsConfigMapKey]), defaults); err != nil {
+		return nil, fmt.Errorf("invalid %s in ConfigMap %s/%s: %w", DefaultsConfigMapKey, d.namespace, DefaultsConfigMapName, err)
+	}
+
+	return defaults, nil
+}
+
+// ConfigMapにもspecにも指定されていない場合のデフォルト値
+func builtinDefaults() *NginxSpec {
+	replicas := int32(1)
+	port := DefaultPort
+	probe := func() *corev1.Probe {
+		return &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromString(PortName)},
+			},
+		}
+	}
+	return &NginxSpec{
+		Replicas:       &replicas,
+		Image:          BuiltinImage(),
+		Service:        ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+		Port:           &port,
+		ReadinessProbe: probe(),
+		LivenessProbe:  probe(),
+	}
+}
+
+// ApplyBuiltinDefaults はWebhookを経由せずにNginxを扱う場合(kubectl nginx renderなど)に
+// specに指定されていない値を組み込みのデフォルト値で埋める(NginxClassとConfigMapは参照しない)
+func (r *Nginx) ApplyBuiltinDefaults() {
+	r.Spec.applyHardenedDefaults()
+	r.Spec.applyDefaults(builtinDefaults())
+}
+
+// spec.securityContext.hardenedの場合に非rootで動作するImageとPortを設定する
+func (s *NginxSpec) applyHardenedDefaults() {
+	if !s.IsHardened() {
+		return
+	}
+	port := HardenedPort
+	s.applyDefaults(&NginxSpec{Image: HardenedImage, Port: &port})
+}
+
+// hardenedを有効にした場合に、作成時に保存された組み込みのデフォルト値(rootで動作するImageと80番Port)が
+// 変更されずに残っていれば削除し、hardenedのデフォルト値で埋め直す
+func (s *NginxSpec) clearBuiltinDefaultsForHardened(old *NginxSpec) {
+	if !s.IsHardened() || old.IsHardened() {
+		return
+	}
+	if s.Port != nil && *s.Port == DefaultPort && old.Port != nil && *old.Port == DefaultPort {
+		s.Port = nil
+	}
+	if s.Image == BuiltinImage() && old.Image == s.Image {
+		s.Image = ""
+	}
+}
+
+// specに指定されていない値をdefaultsの値で埋める
+func (s *NginxSpec) applyDefaults(defaults *NginxSpec) {
+	if s.Replicas == nil && defaults.Replicas != nil {
+		replicas := *defaults.Replicas
+		s.Replicas = &replicas
+	}
+	if s.Service.Type == "" {
+		s.Service.Type = defaults.Service.Type
+	}
+	if s.Image == "" {
+		s.Image = defaults.Image
+	}
+	if s.Port == nil && defaults.Port != nil {
+		port := *defaults.Port
+		s.Port = &port
+	}
+
+	// requests/limitsはResourceごとに埋める
+	if defaults.Resources != nil {
+		if s.Resources == nil {
+			s.Resources = &corev1.ResourceRequirements{}
+		}
+		s.Resources.Requests = mergeResourceList(s.Resources.Requests, defaults.Resources.Requests)
+		s.Resources.Limits = mergeResourceList(s.Resources.Limits, defaults.Resources.Limits)
+	}
+
+	if s.ReadinessProbe == nil && defaults.ReadinessProbe != nil {
+		s.ReadinessProbe = defaults.ReadinessProbe.DeepCopy()
+	}
+	if s.LivenessProbe == nil && defaults.LivenessProbe != nil {
+		s.LivenessProbe = defaults.LivenessProbe.DeepCopy()
+	}
+	DefaultProbe(s.ReadinessProbe)
+	DefaultProbe(s.LivenessProbe)
+}
+
+// dstに存在しないResourceをsrcから追加する
+func mergeResourceList(dst, src corev1.ResourceList) corev1.ResourceList {
+	for name, quantity := range src {
+		if dst == nil {
+			dst = corev1.ResourceList{}
+		}
+		if _, ok := dst[name]; !ok {
+			dst[name] = quantity.DeepCopy()
+		}
+	}
+	return dst
+}
+
+// DefaultProbe はProbeの省略された値をKubernetesのデフォルト値で埋める
+// (DeploymentにはAPI Serverがデフォルト値を設定するので、specと一致させて不要な更新を防ぐ)
+func DefaultProbe(probe *corev1.Probe) {
+	if probe == nil {
+		return
+	}
+	if probe.HTTPGet != nil && probe.HTTPGet.Scheme == "" {
+		probe.HTTPGet.Scheme = corev1.URISchemeHTTP
+	}
+	if probe.TimeoutSeconds == 0 {
+		probe.TimeoutSeconds = 1
+	}
+	if probe.PeriodSeconds == 0 {
+		probe.PeriodSeconds = 10
+	}
+	if probe.SuccessThreshold == 0 {
+		probe.SuccessThreshold = 1
+	}
+	if probe.FailureThreshold == 0 {
+		probe.FailureThreshold = 3
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// "true"の場合はNginxの削除を拒否し、"false"の場合は本番環境のLoadBalancerの保護も無効にするAnnotation
+	DeletionProtectionAnnotation = "nginx.my.domain/deletion-protection"
+)
+
+// ServiceがLoadBalancerのアドレスを持つNginxの削除を拒否するNamespaceのLabel Selector
+// (nilの場合はAnnotationによる保護のみ行う)
+var ProtectedNamespaceSelector labels.Selector
+
+// Nginxの削除を拒否すべきかを確認する
+//
+//	①Annotationが"true"の場合は拒否する
+//	②Annotationが"false"でなく、ProtectedNamespaceSelectorに一致するNamespaceで
+//	  ServiceにLoadBalancerのアドレスが割り当てられている場合は拒否する
+//
+// Namespaceの削除中はNamespaceの削除が止まらないように許可する
+func (v *nginxValidator) validateDelete(ctx context.Context, r *Nginx) admission.Response {
+	protection := r.Annotations[DeletionProtectionAnnotation]
+	if protection == "false" || (protection != "true" && ProtectedNamespaceSelector == nil) {
+		return admission.Allowed("")
+	}
+
+	var namespace corev1.Namespace
+	if err := v.reader.Get(ctx, client.ObjectKey{Name: r.Namespace}, &namespace); err != nil {
+		if apierrors.IsNotFound(err) {
+			return admission.Allowed("")
+		}
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if namespace.DeletionTimestamp != nil {
+		return admission.Allowed("namespace is being deleted")
+	}
+
+	if protection == "true" {
+		return admission.Denied(fmt.Sprintf("Nginx %s/%s is protected from deletion; remove the %s annotation to delete it",
+			r.Namespace, r.Name, DeletionProtectionAnnotation))
+	}
+
+	if !ProtectedNamespaceSelector.Matches(labels.Set(namespace.Labels)) {
+		return admission.Allowed("")
+	}
+
+	address, err := v.loadBalancerAddress(ctx, r)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if address != "" {
+		return admission.Denied(fmt.Sprintf("Nginx %s/%s exposes the LoadBalancer address %s in a protected namespace; annotate it with %s=\"false\" to delete it",
+			r.Namespace, r.Name, address, DeletionProtectionAnnotation))
+	}
+
+	return admission.Allowed("")
+}
+
+// Nginxが管理するServiceに割り当てられたLoadBalancerのアドレスを返す(割り当てられていない場合は空)
+func (v *nginxValidator) loadBalancerAddress(ctx context.Context, r *Nginx) (string, error) {
+	name := r.Status.ServiceName
+	if name == "" {
+		name = NamingPolicy.Service(r.Name)
+	}
+
+	var service corev1.Service
+	if err := v.reader.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: name}, &service); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return "", nil
+	}
+
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP, nil
+		}
+		if ingress.Hostname != "" {
+			return ingress.Hostname, nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/component-base/featuregate"
+
+	"example.com/nginx-controller/pkg/features"
+)
+
+// 無効なFeature Gateの機能を使用するspecの項目を拒否するメソッド
+// 更新時は変更前から設定されている値は許可する(Feature Gateを無効にしても既存のNginxを更新できるようにする)
+//
+//	old: 更新前のNginx(作成時はnil)
+func (r *Nginx) validateFeatureGates(old *Nginx) field.ErrorList {
+	var errs field.ErrorList
+
+	spec := field.NewPath("spec")
+	var oldSpec NginxSpec
+	var oldAnnotations map[string]string
+	if old != nil {
+		oldSpec = old.Spec
+		oldAnnotations = old.Annotations
+	}
+	rollbackTo, rollbackToUsed := r.Annotations[RollbackToAnnotation]
+
+	gated := []struct {
+		feature featuregate.Feature
+		path    *field.Path
+		value   interface{}
+		old     interface{}
+		used    bool
+	}{
+		{features.BlueGreen, spec.Child("strategy"), r.Spec.Strategy, oldSpec.Strategy,
+			r.Spec.Strategy.Type == BlueGreenStrategyType || r.Spec.Strategy.BlueGreen != nil},
+		{features.HotReload, spec.Child("config").Child("reloadStrategy"), reloadStrategy(r.Spec.Config), reloadStrategy(oldSpec.Config),
+			reloadStrategy(r.Spec.Config) == HotReloadReloadStrategy},
+		{features.SpecRollback, spec.Child("rollbackTo"), r.Spec.RollbackTo, oldSpec.RollbackTo,
+			r.Spec.RollbackTo != nil},
+		{features.SpecRollback, field.NewPath("metadata", "annotations").Key(RollbackToAnnotation), rollbackTo, oldAnnotations[RollbackToAnnotation],
+			rollbackToUsed},
+		{features.DisruptionBudget, spec.Child("disruptionBudget"), r.Spec.DisruptionBudget, oldSpec.DisruptionBudget,
+			r.Spec.DisruptionBudget != nil},
+	}
+	for _, g := range gated {
+		if !g.used || features.Enabled(g.feature) {
+			continue
+		}
+		if old != nil && apiequality.Semantic.DeepEqual(g.value, g.old) {
+			continue
+		}
+		errs = append(errs, field.Forbidden(g.path, fmt.Sprintf("requires the %s feature gate, which is disabled", g.feature)))
+	}
+
+	return errs
+}
+
+func reloadStrategy(config *NginxConfig) ReloadStrategy {
+	if config == nil {
+		return ""
+	}
+	return config.ReloadStrategy
+}
@@ -0,0 +1,495 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// HotReloadの場合にsidecarが読み込んだ設定を返すPort(spec.portには指定できない)
+	ReloaderPort = int32(9533)
+
+	// BlueGreenの場合にpreviewのcolorへの切り替えを指示するAnnotation("true"でpromote)
+	// previewの全てのPodがAvailableになってから切り替え、展開中のpreviewがない場合は削除される
+	PromoteAnnotation = "nginx.my.domain/promote"
+
+	// spec.rollbackToの代わりにrollback先のrevisionを指定するAnnotation
+	RollbackToAnnotation = "nginx.my.domain/rollback-to"
+
+	// 値が変更されるとPod Templateに反映してPodを再作成するAnnotation(kubectl nginx reloadで付与)
+	RestartedAtAnnotation = "nginx.my.domain/restarted-at"
+)
+
+// NginxSpec defines the desired state of Nginx
+type NginxSpec struct {
+	// デフォルト値と制約を参照するNginxClassの名前
+	// 未指定の場合はデフォルトのNginxClassがMutation Webhookにより設定される
+	// +optional
+	NginxClassName string `json:"nginxClassName,omitempty"`
+
+	// 未指定の場合はMutation Webhookがデフォルト値を設定する
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// nginxコンテナのImage(未指定の場合はMutation Webhookがデフォルト値を設定する)
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// nginxコンテナがListenするPort
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+
+	// nginxコンテナのResource requests/limits
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// nginxコンテナのReadiness Probe
+	// +optional
+	ReadinessProbe *corev1.Probe `json:"readinessProbe,omitempty"`
+
+	// nginxコンテナのLiveness Probe
+	// +optional
+	LivenessProbe *corev1.Probe `json:"livenessProbe,omitempty"`
+
+	// Nginxを公開するServiceの設定
+	// +optional
+	Service ServiceSpec `json:"service,omitempty"`
+
+	// Podの更新方式
+	// +optional
+	Strategy NginxStrategy `json:"strategy,omitempty"`
+
+	// 管理するDeploymentのRollingUpdateに関する設定
+	// +optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
+
+	// ControllerRevisionとして保持するReadyになったspecの数
+	// +kubebuilder:default=5
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	SpecHistoryLimit *int32 `json:"specHistoryLimit,omitempty"`
+
+	// 指定したrevisionのspecにrollbackする(rollback後にControllerが削除する)
+	// +optional
+	RollbackTo *int64 `json:"rollbackTo,omitempty"`
+
+	// nginxの設定(未指定の場合はImageのデフォルト設定を使用する)
+	// +optional
+	Config *NginxConfig `json:"config,omitempty"`
+
+	// 管理するPodのPodDisruptionBudget(未指定の場合は作成しない)
+	// +optional
+	DisruptionBudget *DisruptionBudgetSpec `json:"disruptionBudget,omitempty"`
+
+	// 管理するPodの通信を許可するNetworkPolicy(未指定の場合は作成しない)
+	// +optional
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// Podとコンテナのsecurity context(未指定の場合はImageのデフォルトのユーザーで実行する)
+	// +optional
+	SecurityContext *SecurityContextSpec `json:"securityContext,omitempty"`
+
+	// PodのServiceAccount(未指定の場合はNamespaceのdefaultのServiceAccountを使用する)
+	// +optional
+	ServiceAccount *ServiceAccountSpec `json:"serviceAccount,omitempty"`
+
+	// nginxコンテナの環境変数(公式Imageの/etc/nginx/templatesのenvsubstなどで使用する)
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// nginxコンテナの環境変数に展開するConfigMap/Secret
+	// 参照しているConfigMap/Secret(env[].valueFromを含む)の内容が変更された場合はPodを再作成する
+	// +optional
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+
+	// Controllerが生成するPod Templateに追加する設定(sidecar、init container、Volumeなど)
+	// Controllerが管理する項目(nginxコンテナ、Label、Volumeなど)と衝突する値は指定できない
+	// +optional
+	PodTemplate *PodTemplateOverrides `json:"podTemplate,omitempty"`
+}
+
+// ServiceSpec defines the Service exposing Nginx
+type ServiceSpec struct {
+	// 未指定の場合はMutation Webhookがデフォルト値を設定する
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// Typeが"NodePort"または"LoadBalancer"の場合のみ指定できる
+	// +kubebuilder:validation:Enum=Cluster;Local
+	// +optional
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+
+	// Typeが"LoadBalancer"の場合のみ指定できる(Serviceの作成後は変更できない)
+	// +optional
+	LoadBalancerClass *string `json:"loadBalancerClass,omitempty"`
+
+	// Typeが"LoadBalancer"の場合のみ指定できる(CIDR形式)
+	// +optional
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+}
+
+// DisruptionBudgetSpec defines the PodDisruptionBudget of the managed pods
+// MinAvailableとMaxUnavailableはどちらか一方のみ指定できる
+type DisruptionBudgetSpec struct {
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// NetworkPolicySpec defines the NetworkPolicy of the managed pods
+type NetworkPolicySpec struct {
+	// nginxのPortへの通信を許可する送信元(Namespace/Pod Selector/CIDR)
+	// 空の場合はnginxのPortへの通信を全て拒否する
+	// +optional
+	Ingress []networkingv1.NetworkPolicyPeer `json:"ingress,omitempty"`
+
+	// nginxからの通信を許可するupstream
+	// 指定した場合のみEgressを制限する(名前解決のためPort 53への通信は常に許可する)
+	// +optional
+	Upstreams []UpstreamSpec `json:"upstreams,omitempty"`
+}
+
+// UpstreamSpec defines an upstream nginx is allowed to connect to
+// ServiceとCIDRはどちらか一方のみ指定できる
+type UpstreamSpec struct {
+	// upstreamのService(ServiceのselectorとtargetPortからEgressのルールを生成する)
+	// +optional
+	Service *UpstreamServiceReference `json:"service,omitempty"`
+
+	// Service以外のupstreamのCIDR
+	// +optional
+	CIDR string `json:"cidr,omitempty"`
+
+	// 許可するPort(未指定の場合はServiceのtargetPort、CIDRの場合は全てのPort)
+	// +optional
+	Ports []networkingv1.NetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// UpstreamServiceReference refers to a Service used as an upstream
+type UpstreamServiceReference struct {
+	Name string `json:"name"`
+
+	// 未指定の場合はNginxと同じNamespace
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// SecurityContextSpec defines the security context of the managed pods
+type SecurityContextSpec struct {
+	// trueの場合はPod Security Standardsの"restricted"を満たす設定で実行する
+	//   ・Imageとportが未指定の場合は非rootで動作するImage(HardenedImage)とPort 8080を使用する
+	//   ・runAsNonRoot、readOnlyRootFilesystem(/tmp、/var/cache/nginx、/var/runにはemptyDirをマウントする)
+	//   ・全てのcapabilitiesのdrop、allowPrivilegeEscalation: false、seccompProfile: RuntimeDefault
+	// +optional
+	Hardened bool `json:"hardened,omitempty"`
+
+	// Podのsecurity context(指定した項目はhardenedの設定より優先する)
+	// +optional
+	Pod *corev1.PodSecurityContext `json:"pod,omitempty"`
+
+	// nginxコンテナとsidecarのsecurity context(指定した項目はhardenedの設定より優先する)
+	// +optional
+	Container *corev1.SecurityContext `json:"container,omitempty"`
+}
+
+// ServiceAccountSpec defines the ServiceAccount of the managed pods
+type ServiceAccountSpec struct {
+	// trueの場合はControllerがServiceAccountを作成して管理する
+	// falseの場合はnameの既存のServiceAccountを使用する
+	// +optional
+	Create bool `json:"create,omitempty"`
+
+	// ServiceAccountの名前(createがtrueで未指定の場合は"sa-<Nginxの名前>")
+	// createがtrueの場合、Controllerが作成していない既存のServiceAccount(defaultなど)の名前は使用できない
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// 作成するServiceAccountのAnnotation(Workload Identityの設定など、createがtrueの場合のみ指定できる)
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// PodにAPIのTokenをマウントするか(未指定の場合はfalse)
+	// +optional
+	AutomountToken *bool `json:"automountToken,omitempty"`
+}
+
+// PodTemplateOverrides defines additions to the pod template generated by the controller
+//
+// Container/Volumeのschemaを埋め込むとCRDがclient-side applyの上限(256KiB)を超えるため、
+// containers、initContainers、volumesはschemaを持たずWebhookで検証する
+type PodTemplateOverrides struct {
+	// Podに追加するLabel("app"、"controller"、"color"はControllerが使用するため指定できない)
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Podに追加するAnnotation(Controllerが使用するAnnotationはControllerの値を優先する)
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// nginxコンテナと一緒に実行するsidecar(ログの転送、認証proxyなど)
+	// +optional
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Containers []corev1.Container `json:"containers,omitempty"`
+
+	// nginxコンテナの前に実行するinit container(証明書の取得など)
+	// +optional
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+
+	// Podに追加するVolume
+	// +optional
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+
+	// nginxコンテナに追加するVolumeMount
+	// +optional
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+}
+
+// IsHardened はspec.securityContext.hardenedがtrueかを返す
+func (s *NginxSpec) IsHardened() bool {
+	return s.SecurityContext != nil && s.SecurityContext.Hardened
+}
+
+// NginxConfig defines the nginx configuration rendered into the managed ConfigMap
+type NginxConfig struct {
+	// /etc/nginx/conf.d/default.confとして配置する設定(httpコンテキスト)
+	// 反映前に"nginx -t"で検証され、エラーの場合はPodに反映されない
+	Content string `json:"content"`
+
+	// 設定の変更をPodに反映する方法
+	// +kubebuilder:default=Restart
+	// +optional
+	ReloadStrategy ReloadStrategy `json:"reloadStrategy,omitempty"`
+}
+
+// ReloadStrategy は設定の変更をPodに反映する方法
+// +kubebuilder:validation:Enum=Restart;HotReload
+type ReloadStrategy string
+
+const (
+	// Podを再作成して設定を反映する
+	RestartReloadStrategy ReloadStrategy = "Restart"
+	// sidecarが"nginx -t"で検証してからnginxのmaster processにSIGHUPを送って設定を反映する(Podは再作成しない)
+	HotReloadReloadStrategy ReloadStrategy = "HotReload"
+)
+
+// RolloutSpec defines the rollout settings of the managed Deployment
+type RolloutSpec struct {
+	// RollingUpdate中に追加で作成できるPodの数(または割合)
+	// +optional
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+
+	// RollingUpdate中にUnavailableになってもよいPodの数(または割合)
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+
+	// PodがReadyになってからAvailableとみなされるまでの秒数
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinReadySeconds *int32 `json:"minReadySeconds,omitempty"`
+
+	// Rolloutが進まない場合にProgressDeadlineExceededとみなすまでの秒数
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+
+	// 保持する古いReplicaSetの数
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+}
+
+// NginxStrategyType はPodの更新方式の種類
+// +kubebuilder:validation:Enum=RollingUpdate;BlueGreen
+type NginxStrategyType string
+
+const (
+	// 1つのDeploymentをRollingUpdateで更新する
+	RollingUpdateStrategyType NginxStrategyType = "RollingUpdate"
+	// blue/greenの2つのDeploymentを切り替えて更新する
+	BlueGreenStrategyType NginxStrategyType = "BlueGreen"
+)
+
+// NginxStrategy defines how the pods of Nginx are updated
+type NginxStrategy struct {
+	// +kubebuilder:default=RollingUpdate
+	Type NginxStrategyType `json:"type,omitempty"`
+
+	// Typeが"BlueGreen"の場合の設定
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+}
+
+// BlueGreenStrategy defines the behavior of the blue/green deployment
+type BlueGreenStrategy struct {
+	// trueの場合、previewのDeploymentが全てAvailableになった時点で自動的にpromoteする
+	// falseの場合は"nginx.my.domain/promote"Annotationが付与されるまでpromoteしない
+	AutoPromotionEnabled bool `json:"autoPromotionEnabled,omitempty"`
+
+	// promote後に旧colorのDeploymentをscale downするまでの秒数
+	// +kubebuilder:default=30
+	// +kubebuilder:validation:Minimum=0
+	ScaleDownDelaySeconds *int32 `json:"scaleDownDelaySeconds,omitempty"`
+}
+
+// NginxStatus defines the observed state of Nginx
+type NginxStatus struct {
+	DeploymentName    string `json:"deploymentName"`
+	AvailableReplicas int32  `json:"availableReplicas"`
+	ServiceName       string `json:"serviceName"`
+
+	ClusterIP string `json:"clusterIP,omitempty"`
+
+	ExternalIP string `json:"externalIP,omitempty"`
+
+	// BlueGreenの場合にServiceがトラフィックを流しているcolor(blue/green)
+	ActiveColor string `json:"activeColor,omitempty"`
+
+	// BlueGreenの場合にpreview用のcolorを公開するService
+	PreviewServiceName string `json:"previewServiceName,omitempty"`
+
+	// 現在のspecに対応するrevision(Readyになったことがない場合は0)
+	// +optional
+	CurrentRevision int64 `json:"currentRevision,omitempty"`
+
+	// rollbackできるspecのrevision一覧
+	// +optional
+	Revisions []SpecRevision `json:"revisions,omitempty"`
+
+	// spec.config.contentのハッシュ値(md5)
+	// +optional
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// 管理しているPodの集計とReadyでない、または古いImage/設定で動いているPod
+	// +optional
+	Pods *PodsStatus `json:"pods,omitempty"`
+
+	// Nginxの状態を表すCondition
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// SpecRevision describes a ready spec of Nginx stored in a ControllerRevision
+type SpecRevision struct {
+	Revision int64 `json:"revision"`
+
+	// specを保存しているControllerRevisionの名前
+	Name string `json:"name"`
+
+	Image string `json:"image,omitempty"`
+
+	CreationTimestamp metav1.Time `json:"creationTimestamp"`
+}
+
+// PodsStatus summarizes the pods managed by Nginx
+type PodsStatus struct {
+	Total int32 `json:"total"`
+	Ready int32 `json:"ready"`
+
+	// 最新のImageと設定で動いているPodの数
+	UpToDate int32 `json:"upToDate"`
+
+	// ReadyでないかUpToDateでないPod(Pod数が多い場合に備えて先頭の一部のみ)
+	// +optional
+	UnhealthyPods []PodStatus `json:"unhealthyPods,omitempty"`
+}
+
+// PodStatus describes a pod managed by Nginx
+type PodStatus struct {
+	Name string `json:"name"`
+
+	// +optional
+	Node string `json:"node,omitempty"`
+
+	Ready bool `json:"ready"`
+
+	// Pod内の全てのコンテナの再起動回数の合計
+	Restarts int32 `json:"restarts"`
+
+	// nginxコンテナのImage
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Podのnginxが読み込んでいる設定のハッシュ値(md5)
+	// +optional
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// HotReloadの場合に最後に設定を読み込んだ時刻
+	// +optional
+	LastReloadTime *metav1.Time `json:"lastReloadTime,omitempty"`
+}
+
+const (
+	// 管理するDeploymentのRolloutがprogressDeadlineSeconds以内に完了しなかったことを表すCondition
+	ConditionDegraded = "Degraded"
+
+	// spec.configが"nginx -t"による検証に成功したかを表すCondition
+	ConditionConfigValid = "ConfigValid"
+
+	// spec.env/envFromで参照しているConfigMap/Secret(optionalを除く)が全て存在するかを表すCondition
+	ConditionEnvSourcesFound = "EnvSourcesFound"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:storageversion
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced
+// +kubebuilder:resource:shortName="ng"
+// +kubebuilder:printcolumn:JSONPath=".status.availableReplicas",name=Replicas,type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.serviceName",name=Service_Name,type=string
+// +kubebuilder:printcolumn:JSONPath=".status.clusterIP",name=Cluster-IP,type=string
+// +kubebuilder:printcolumn:JSONPath=".status.externalIP",name=External-IP,type=string
+// +kubebuilder:printcolumn:JSONPath=".status.pods.upToDate",name=Up-To-Date,type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.activeColor",name=Active_Color,type=string
+
+// Nginx is the Schema for the nginxes API
+type Nginx struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NginxSpec   `json:"spec,omitempty"`
+	Status NginxStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NginxList contains a list of Nginx
+type NginxList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Nginx `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Nginx{}, &NginxList{})
+}
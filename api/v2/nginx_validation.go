@@ -0,0 +1,514 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// specの項目間の整合性を確認するメソッド
+func (r *Nginx) validateNginxSpec() field.ErrorList {
+	nginxlog.Info("[Validation] Check Nginx spec", "name", r.Name)
+
+	var errs field.ErrorList
+
+	spec := field.NewPath("spec")
+	errs = append(errs, r.validateService(spec.Child("service"))...)
+	errs = append(errs, r.validateDisruptionBudget(spec.Child("disruptionBudget"))...)
+	errs = append(errs, r.validatePorts(spec)...)
+	errs = append(errs, r.validateNetworkPolicy(spec.Child("networkPolicy"))...)
+	errs = append(errs, r.validateSecurityContext(spec)...)
+	errs = append(errs, r.validateServiceAccount(spec.Child("serviceAccount"))...)
+	errs = append(errs, r.validateEnv(spec)...)
+	errs = append(errs, r.validatePodTemplate(spec.Child("podTemplate"))...)
+
+	return errs
+}
+
+// spec.serviceのTypeに依存する項目を確認する
+func (r *Nginx) validateService(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	service := r.Spec.Service
+	loadBalancer := service.Type == corev1.ServiceTypeLoadBalancer
+	external := loadBalancer || service.Type == corev1.ServiceTypeNodePort
+
+	if service.ExternalTrafficPolicy != "" && !external {
+		errs = append(errs, field.Forbidden(path.Child("externalTrafficPolicy"), "may only be set when type is NodePort or LoadBalancer"))
+	}
+	if service.LoadBalancerClass != nil && !loadBalancer {
+		errs = append(errs, field.Forbidden(path.Child("loadBalancerClass"), "may only be set when type is LoadBalancer"))
+	}
+	if len(service.LoadBalancerSourceRanges) > 0 && !loadBalancer {
+		errs = append(errs, field.Forbidden(path.Child("loadBalancerSourceRanges"), "may only be set when type is LoadBalancer"))
+	}
+	for i, cidr := range service.LoadBalancerSourceRanges {
+		if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+			errs = append(errs, field.Invalid(path.Child("loadBalancerSourceRanges").Index(i), cidr, "must be a CIDR such as 10.0.0.0/8"))
+		}
+	}
+
+	return errs
+}
+
+// spec.disruptionBudgetがspec.replicasに対して自発的なPodの退避を不可能にしないかを確認する
+func (r *Nginx) validateDisruptionBudget(path *field.Path) field.ErrorList {
+	budget := r.Spec.DisruptionBudget
+	if budget == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+
+	switch {
+	case budget.MinAvailable != nil && budget.MaxUnavailable != nil:
+		return append(errs, field.Invalid(path, "", "minAvailable and maxUnavailable are mutually exclusive"))
+	case budget.MinAvailable == nil && budget.MaxUnavailable == nil:
+		return append(errs, field.Required(path, "either minAvailable or maxUnavailable is required"))
+	}
+
+	replicas := 1
+	if r.Spec.Replicas != nil {
+		replicas = int(*r.Spec.Replicas)
+	}
+
+	if budget.MinAvailable != nil {
+		minAvailable, err := intstr.GetScaledValueFromIntOrPercent(budget.MinAvailable, replicas, true)
+		if err != nil {
+			errs = append(errs, field.Invalid(path.Child("minAvailable"), budget.MinAvailable.String(), err.Error()))
+		} else if replicas > 0 && minAvailable >= replicas {
+			errs = append(errs, field.Invalid(path.Child("minAvailable"), budget.MinAvailable.String(),
+				fmt.Sprintf("must be less than spec.replicas (%d), otherwise no pod can be evicted voluntarily", replicas)))
+		}
+	}
+	if budget.MaxUnavailable != nil {
+		maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(budget.MaxUnavailable, replicas, false)
+		if err != nil {
+			errs = append(errs, field.Invalid(path.Child("maxUnavailable"), budget.MaxUnavailable.String(), err.Error()))
+		} else if replicas > 0 && maxUnavailable < 1 {
+			errs = append(errs, field.Invalid(path.Child("maxUnavailable"), budget.MaxUnavailable.String(),
+				fmt.Sprintf("must allow at least one of spec.replicas (%d) to be unavailable, otherwise no pod can be evicted voluntarily", replicas)))
+		}
+	}
+
+	return errs
+}
+
+// spec.networkPolicyのCIDRとupstreamの指定方法を確認する
+func (r *Nginx) validateNetworkPolicy(path *field.Path) field.ErrorList {
+	policy := r.Spec.NetworkPolicy
+	if policy == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+
+	for i, peer := range policy.Ingress {
+		peerPath := path.Child("ingress").Index(i)
+		if peer.IPBlock != nil && (peer.PodSelector != nil || peer.NamespaceSelector != nil) {
+			errs = append(errs, field.Invalid(peerPath, "", "ipBlock may not be combined with podSelector or namespaceSelector"))
+		}
+		if peer.IPBlock == nil && peer.PodSelector == nil && peer.NamespaceSelector == nil {
+			errs = append(errs, field.Required(peerPath, "one of podSelector, namespaceSelector or ipBlock is required"))
+		}
+		if peer.IPBlock != nil {
+			errs = append(errs, validateCIDR(peerPath.Child("ipBlock").Child("cidr"), peer.IPBlock.CIDR)...)
+			for j, except := range peer.IPBlock.Except {
+				errs = append(errs, validateCIDR(peerPath.Child("ipBlock").Child("except").Index(j), except)...)
+			}
+		}
+	}
+
+	for i, upstream := range policy.Upstreams {
+		upstreamPath := path.Child("upstreams").Index(i)
+		switch {
+		case upstream.Service != nil && upstream.CIDR != "":
+			errs = append(errs, field.Invalid(upstreamPath, "", "service and cidr are mutually exclusive"))
+		case upstream.Service != nil:
+			if upstream.Service.Name == "" {
+				errs = append(errs, field.Required(upstreamPath.Child("service").Child("name"), ""))
+			}
+		case upstream.CIDR != "":
+			errs = append(errs, validateCIDR(upstreamPath.Child("cidr"), upstream.CIDR)...)
+		default:
+			errs = append(errs, field.Required(upstreamPath, "either service or cidr is required"))
+		}
+	}
+
+	return errs
+}
+
+// spec.securityContext.hardenedの場合に非rootで実行できない設定を確認する
+func (r *Nginx) validateSecurityContext(spec *field.Path) field.ErrorList {
+	if !r.Spec.IsHardened() {
+		return nil
+	}
+
+	var errs field.ErrorList
+
+	path := spec.Child("securityContext")
+	if r.Spec.Port != nil && *r.Spec.Port < 1024 {
+		errs = append(errs, field.Invalid(spec.Child("port"), *r.Spec.Port, "must be 1024 or higher when securityContext.hardened is true, since nginx runs as non-root"))
+	}
+	if pod := r.Spec.SecurityContext.Pod; pod != nil && pod.RunAsNonRoot != nil && !*pod.RunAsNonRoot {
+		errs = append(errs, field.Forbidden(path.Child("pod").Child("runAsNonRoot"), "may not be false when hardened is true"))
+	}
+	if pod := r.Spec.SecurityContext.Pod; pod != nil && pod.RunAsUser != nil && *pod.RunAsUser == 0 {
+		errs = append(errs, field.Forbidden(path.Child("pod").Child("runAsUser"), "may not be 0 when hardened is true"))
+	}
+	if container := r.Spec.SecurityContext.Container; container != nil {
+		if container.RunAsNonRoot != nil && !*container.RunAsNonRoot {
+			errs = append(errs, field.Forbidden(path.Child("container").Child("runAsNonRoot"), "may not be false when hardened is true"))
+		}
+		if container.RunAsUser != nil && *container.RunAsUser == 0 {
+			errs = append(errs, field.Forbidden(path.Child("container").Child("runAsUser"), "may not be 0 when hardened is true"))
+		}
+		if container.Privileged != nil && *container.Privileged {
+			errs = append(errs, field.Forbidden(path.Child("container").Child("privileged"), "may not be true when hardened is true"))
+		}
+	}
+
+	return errs
+}
+
+// spec.serviceAccountの名前とAnnotationを確認する
+func (r *Nginx) validateServiceAccount(path *field.Path) field.ErrorList {
+	sa := r.Spec.ServiceAccount
+	if sa == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+
+	if !sa.Create && sa.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), "name of the existing ServiceAccount is required when create is false"))
+	}
+	if sa.Name != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(sa.Name) {
+			errs = append(errs, field.Invalid(path.Child("name"), sa.Name, msg))
+		}
+	}
+	if !sa.Create && len(sa.Annotations) > 0 {
+		errs = append(errs, field.Forbidden(path.Child("annotations"), "may only be set when create is true"))
+	}
+	errs = append(errs, apivalidation.ValidateAnnotations(sa.Annotations, path.Child("annotations"))...)
+
+	return errs
+}
+
+// spec.env/envFromの名前と参照先を確認する
+func (r *Nginx) validateEnv(spec *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	for i, env := range r.Spec.Env {
+		path := spec.Child("env").Index(i)
+		if env.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), ""))
+		} else {
+			for _, msg := range validation.IsEnvVarName(env.Name) {
+				errs = append(errs, field.Invalid(path.Child("name"), env.Name, msg))
+			}
+		}
+		if env.ValueFrom == nil {
+			continue
+		}
+		if env.Value != "" {
+			errs = append(errs, field.Invalid(path.Child("valueFrom"), "", "may not be specified when value is not empty"))
+		}
+		sources := 0
+		if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+			sources++
+			errs = append(errs, validateEnvSourceName(path.Child("valueFrom", "configMapKeyRef", "name"), ref.Name)...)
+		}
+		if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+			sources++
+			errs = append(errs, validateEnvSourceName(path.Child("valueFrom", "secretKeyRef", "name"), ref.Name)...)
+		}
+		if env.ValueFrom.FieldRef != nil {
+			sources++
+		}
+		if env.ValueFrom.ResourceFieldRef != nil {
+			sources++
+		}
+		if sources != 1 {
+			errs = append(errs, field.Invalid(path.Child("valueFrom"), "", "must specify exactly one of configMapKeyRef, secretKeyRef, fieldRef or resourceFieldRef"))
+		}
+	}
+
+	for i, env := range r.Spec.EnvFrom {
+		path := spec.Child("envFrom").Index(i)
+		if env.Prefix != "" {
+			for _, msg := range validation.IsEnvVarName(env.Prefix) {
+				errs = append(errs, field.Invalid(path.Child("prefix"), env.Prefix, msg))
+			}
+		}
+		switch {
+		case env.ConfigMapRef != nil && env.SecretRef != nil:
+			errs = append(errs, field.Invalid(path, "", "may not have more than one of configMapRef or secretRef"))
+		case env.ConfigMapRef != nil:
+			errs = append(errs, validateEnvSourceName(path.Child("configMapRef", "name"), env.ConfigMapRef.Name)...)
+		case env.SecretRef != nil:
+			errs = append(errs, validateEnvSourceName(path.Child("secretRef", "name"), env.SecretRef.Name)...)
+		default:
+			errs = append(errs, field.Required(path, "must specify configMapRef or secretRef"))
+		}
+	}
+
+	return errs
+}
+
+func validateEnvSourceName(path *field.Path, name string) field.ErrorList {
+	if name == "" {
+		return field.ErrorList{field.Required(path, "")}
+	}
+	var errs field.ErrorList
+	for _, msg := range validation.IsDNS1123Subdomain(name) {
+		errs = append(errs, field.Invalid(path, name, msg))
+	}
+	return errs
+}
+
+// Controllerが使用するため、spec.podTemplateで指定できない名前
+var (
+	reservedPodLabels      = []string{"app", "controller", "color"}
+	reservedContainerNames = []string{"nginx", "config-reloader"}
+	reservedVolumeNames    = []string{"config", "run", "tmp", "cache"}
+)
+
+// spec.podTemplateの値がControllerの管理する値と衝突しないかを確認する
+// containers、initContainers、volumesはCRDのschemaで検証されないため、ここで必須項目も確認する
+func (r *Nginx) validatePodTemplate(path *field.Path) field.ErrorList {
+	pt := r.Spec.PodTemplate
+	if pt == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+
+	errs = append(errs, metav1validation.ValidateLabels(pt.Labels, path.Child("labels"))...)
+	for _, key := range reservedPodLabels {
+		if _, ok := pt.Labels[key]; ok {
+			errs = append(errs, field.Forbidden(path.Child("labels").Key(key), "is reserved for the pod selector of the controller"))
+		}
+	}
+	errs = append(errs, apivalidation.ValidateAnnotations(pt.Annotations, path.Child("annotations"))...)
+
+	volumes := make(map[string]bool)
+	for i, volume := range pt.Volumes {
+		idxPath := path.Child("volumes").Index(i)
+		errs = append(errs, validateName(idxPath.Child("name"), volume.Name, reservedVolumeNames)...)
+		if volumes[volume.Name] {
+			errs = append(errs, field.Duplicate(idxPath.Child("name"), volume.Name))
+		}
+		volumes[volume.Name] = true
+	}
+	// sidecarはControllerが作成するVolume(設定ファイルなど)もマウントできる
+	hasConfig := r.Spec.Config != nil && r.Spec.Config.Content != ""
+	hotReload := hasConfig && r.Spec.Config.ReloadStrategy == HotReloadReloadStrategy
+	mountable := map[string]bool{
+		"config": hasConfig,
+		"tmp":    r.Spec.IsHardened(),
+		"cache":  r.Spec.IsHardened(),
+		"run":    r.Spec.IsHardened() || hotReload,
+	}
+	for name := range volumes {
+		mountable[name] = true
+	}
+
+	for i, mount := range pt.VolumeMounts {
+		idxPath := path.Child("volumeMounts").Index(i)
+		if !volumes[mount.Name] {
+			errs = append(errs, field.NotFound(idxPath.Child("name"), mount.Name))
+		}
+		if mount.MountPath == "" {
+			errs = append(errs, field.Required(idxPath.Child("mountPath"), ""))
+		}
+	}
+
+	// spec.portはDefaulterで設定されるが、未設定の場合はControllerと同じ値で確認する
+	port := DefaultPort
+	if r.Spec.IsHardened() {
+		port = HardenedPort
+	}
+	if r.Spec.Port != nil {
+		port = *r.Spec.Port
+	}
+
+	containers := make(map[string]bool)
+	ports := map[int32]string{port: "nginx"}
+	if hotReload {
+		ports[ReloaderPort] = "config-reloader"
+	}
+	for _, list := range []struct {
+		name       string
+		containers []corev1.Container
+	}{
+		{"initContainers", pt.InitContainers},
+		{"containers", pt.Containers},
+	} {
+		for i, c := range list.containers {
+			idxPath := path.Child(list.name).Index(i)
+			errs = append(errs, validateName(idxPath.Child("name"), c.Name, reservedContainerNames)...)
+			if containers[c.Name] {
+				errs = append(errs, field.Duplicate(idxPath.Child("name"), c.Name))
+			}
+			containers[c.Name] = true
+			if c.Image == "" {
+				errs = append(errs, field.Required(idxPath.Child("image"), ""))
+			}
+			for j, mount := range c.VolumeMounts {
+				if !mountable[mount.Name] {
+					errs = append(errs, field.NotFound(idxPath.Child("volumeMounts").Index(j).Child("name"), mount.Name))
+				}
+			}
+			// init containerは同時に実行されないのでPortの衝突を確認しない
+			if list.name != "containers" {
+				continue
+			}
+			for j, port := range c.Ports {
+				if owner, ok := ports[port.ContainerPort]; ok {
+					errs = append(errs, field.Invalid(idxPath.Child("ports").Index(j).Child("containerPort"), port.ContainerPort, fmt.Sprintf("collides with the port of the %s container", owner)))
+				}
+				ports[port.ContainerPort] = c.Name
+			}
+		}
+	}
+
+	return errs
+}
+
+// spec.podTemplateのコンテナとVolumeの名前を確認する
+func validateName(path *field.Path, name string, reserved []string) field.ErrorList {
+	if name == "" {
+		return field.ErrorList{field.Required(path, "")}
+	}
+	var errs field.ErrorList
+	for _, msg := range validation.IsDNS1123Label(name) {
+		errs = append(errs, field.Invalid(path, name, msg))
+	}
+	for _, v := range reserved {
+		if v == name {
+			errs = append(errs, field.Forbidden(path, fmt.Sprintf("%q is reserved for the controller", name)))
+		}
+	}
+	return errs
+}
+
+func validateCIDR(path *field.Path, cidr string) field.ErrorList {
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return field.ErrorList{field.Invalid(path, cidr, "must be a CIDR such as 10.0.0.0/8")}
+	}
+	return nil
+}
+
+// spec.portと他のPortの衝突、ProbeのPortの参照を確認する
+func (r *Nginx) validatePorts(spec *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	hotReload := r.Spec.Config != nil && r.Spec.Config.Content != "" && r.Spec.Config.ReloadStrategy == HotReloadReloadStrategy
+	if hotReload && r.Spec.Port != nil && *r.Spec.Port == ReloaderPort {
+		errs = append(errs, field.Invalid(spec.Child("port"), *r.Spec.Port, fmt.Sprintf("collides with the config-reloader sidecar port %d", ReloaderPort)))
+	}
+
+	probes := map[string]*corev1.Probe{
+		"readinessProbe": r.Spec.ReadinessProbe,
+		"livenessProbe":  r.Spec.LivenessProbe,
+	}
+	for name, probe := range probes {
+		if probe == nil {
+			continue
+		}
+		var port *intstr.IntOrString
+		var path *field.Path
+		switch {
+		case probe.HTTPGet != nil:
+			port, path = &probe.HTTPGet.Port, spec.Child(name, "httpGet", "port")
+		case probe.TCPSocket != nil:
+			port, path = &probe.TCPSocket.Port, spec.Child(name, "tcpSocket", "port")
+		default:
+			continue
+		}
+		if port.Type == intstr.String && port.StrVal != PortName {
+			errs = append(errs, field.Invalid(path, port.StrVal, fmt.Sprintf("must be %q (the only named port of the nginx container) or a number", PortName)))
+		}
+	}
+
+	return errs
+}
+
+// Controllerが既存のリソースに反映できない項目が変更されていないかを確認するメソッド
+func (r *Nginx) validateNginxUpdate(old *Nginx) field.ErrorList {
+	nginxlog.Info("[Validation] Check immutable fields", "name", r.Name)
+
+	var errs field.ErrorList
+
+	spec := field.NewPath("spec")
+
+	// NginxClassのデフォルト値と制約は作成時に適用されるので、設定後は変更できない
+	if old.Spec.NginxClassName != "" && r.Spec.NginxClassName != old.Spec.NginxClassName {
+		errs = append(errs, field.Invalid(spec.Child("nginxClassName"), r.Spec.NginxClassName, "field is immutable once set"))
+	}
+
+	// ServiceのloadBalancerClassは作成後に変更できない
+	if old.Spec.Service.Type == corev1.ServiceTypeLoadBalancer && r.Spec.Service.Type == corev1.ServiceTypeLoadBalancer &&
+		!apiequality.Semantic.DeepEqual(old.Spec.Service.LoadBalancerClass, r.Spec.Service.LoadBalancerClass) {
+		errs = append(errs, field.Invalid(spec.Child("service", "loadBalancerClass"), r.Spec.Service.LoadBalancerClass, "field is immutable while type is LoadBalancer"))
+	}
+
+	return errs
+}
+
+// 非推奨または意図しない可能性のある設定に対する警告を返すメソッド
+func (r *Nginx) warnings(req admission.Request) []string {
+	var warnings []string
+
+	if req.RequestKind != nil && req.RequestKind.Version == "v1" {
+		warnings = append(warnings, "nginx.my.domain/v1 Nginx is deprecated; use nginx.my.domain/v2 Nginx")
+	}
+
+	if image := r.Spec.Image; image != "" && !strings.Contains(image, "@") {
+		_, tag, found := strings.Cut(image[strings.LastIndex(image, "/")+1:], ":")
+		if !found || tag == "latest" {
+			warnings = append(warnings, fmt.Sprintf("spec.image %q does not pin a version; pods may run different images after a restart", image))
+		}
+	}
+
+	if r.Spec.Strategy.BlueGreen != nil && r.Spec.Strategy.Type != BlueGreenStrategyType {
+		warnings = append(warnings, "spec.strategy.blueGreen is ignored unless spec.strategy.type is BlueGreen")
+	}
+
+	if pt := r.Spec.PodTemplate; pt != nil {
+		for key := range pt.Annotations {
+			if strings.HasPrefix(key, GroupVersion.Group+"/") {
+				warnings = append(warnings, fmt.Sprintf("spec.podTemplate.annotations[%s] may be overwritten by the controller", key))
+			}
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,315 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"example.com/nginx-controller/pkg/naming"
+	"example.com/nginx-controller/pkg/nginxconf"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var nginxlog = logf.Log.WithName("nginx-resource")
+
+// Controllerと同じ名前の生成方法で管理リソースの名前を確認する
+var NamingPolicy = naming.Default()
+
+func (r *Nginx) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	decoder, err := admission.NewDecoder(mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+
+	// Validationはadmission.Warningsを返すためadmission.Handlerとして登録する
+	mgr.GetWebhookServer().Register("/validate-nginx-my-domain-v2-nginx", &webhook.Admission{
+		Handler: &nginxValidator{reader: mgr.GetAPIReader(), decoder: decoder},
+	})
+
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&nginxDefaulter{reader: mgr.GetAPIReader(), namespace: DefaultsNamespace}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-nginx-my-domain-v2-nginx,mutating=true,failurePolicy=fail,sideEffects=None,groups=nginx.my.domain,resources=nginxes,verbs=create;update,versions=v2,name=mnginx.kb.io,admissionReviewVersions=v1
+
+//+kubebuilder:webhook:path=/validate-nginx-my-domain-v2-nginx,mutating=false,failurePolicy=fail,sideEffects=None,groups=nginx.my.domain,resources=nginxes,verbs=create;update;delete,versions=v2,name=vnginx.kb.io,admissionReviewVersions=v1
+
+// Validation
+// NginxClassと変更前のNginxを参照し、エラーとあわせて警告(Warnings)を返すためadmission.Handlerとして実装する
+type nginxValidator struct {
+	reader  client.Reader
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &nginxValidator{}
+
+// Handle implements admission.Handler so a webhook will be registered for the type
+func (v *nginxValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	// 削除時はreq.Objectが空なので削除対象のreq.OldObjectを確認する
+	if req.Operation == admissionv1.Delete {
+		r := &Nginx{}
+		if err := v.decoder.DecodeRaw(req.OldObject, r); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		nginxlog.Info("[Validation] Validate Delete", "name", r.Name)
+		return v.validateDelete(ctx, r)
+	}
+
+	r := &Nginx{}
+	if err := v.decoder.Decode(req, r); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var old *Nginx
+	switch req.Operation {
+	case admissionv1.Create:
+		nginxlog.Info("[Validation] Validate Create", "name", r.Name)
+	case admissionv1.Update:
+		nginxlog.Info("[Validation] Validate Update", "name", r.Name)
+		old = &Nginx{}
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	default:
+		return admission.Allowed("")
+	}
+
+	class, err := v.nginxClass(ctx, r)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	warnings := r.warnings(req)
+	if err := r.validateNginx(old, class); err != nil {
+		return validationResponse(err).WithWarnings(warnings...)
+	}
+
+	return admission.Allowed("").WithWarnings(warnings...)
+}
+
+// spec.nginxClassNameのNginxClassを取得する(未指定または存在しない場合はnil)
+func (v *nginxValidator) nginxClass(ctx context.Context, r *Nginx) (*NginxClass, error) {
+	if r.Spec.NginxClassName == "" {
+		return nil, nil
+	}
+
+	class := &NginxClass{}
+	if err := v.reader.Get(ctx, client.ObjectKey{Name: r.Spec.NginxClassName}, class); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return class, nil
+}
+
+// Validationのエラーをレスポンスに変換する
+// errors.StatusError型のエラーはStatusをそのまま返す
+func validationResponse(err error) admission.Response {
+	var statusErr *apierrors.StatusError
+	if errors.As(err, &statusErr) {
+		return admission.Response{
+			AdmissionResponse: admissionv1.AdmissionResponse{
+				Allowed: false,
+				Result:  &statusErr.ErrStatus,
+			},
+		}
+	}
+	return admission.Denied(err.Error())
+}
+
+// Nginxリソースの内容を確認し、エラーがあればまとめてerrors.StatusError型のエラーを返すメソッド
+//
+//	old: 更新前のNginx(作成時はnil)
+//	class: spec.nginxClassNameのNginxClass(存在しない場合はnil)
+func (r *Nginx) validateNginx(old *Nginx, class *NginxClass) error {
+	var errs field.ErrorList
+
+	errs = append(errs, r.validateNginxName()...)
+	errs = append(errs, r.validateNginxSpec()...)
+	errs = append(errs, r.validateNginxConfig()...)
+	errs = append(errs, r.validateNginxClass(class)...)
+	errs = append(errs, r.validateFeatureGates(old)...)
+	if old != nil {
+		errs = append(errs, r.validateNginxUpdate(old)...)
+	}
+
+	// Validation Webhookに失敗したらerrors.StatusError型のエラーを返す
+	if len(errs) > 0 {
+		err := apierrors.NewInvalid(schema.GroupKind{Group: "nginx", Kind: "Nginx"}, r.Name, errs)
+		nginxlog.Error(err, "validation error", "name", r.Name)
+		return err
+	}
+
+	return nil
+}
+
+// Nginxリソース名と、そこから生成される管理リソースの名前を確認するメソッド
+func (r *Nginx) validateNginxName() field.ErrorList {
+	nginxlog.Info("[Validation] Check Nginx name", "name", r.Name)
+
+	var errs field.ErrorList
+
+	path := field.NewPath("metadata").Child("name")
+
+	// NamingPolicy.MaxNameLengthを超えたらエラー
+	if NamingPolicy.MaxNameLength > 0 && len(r.Name) > NamingPolicy.MaxNameLength {
+		errs = append(errs, field.Invalid(path, r.Name, fmt.Sprintf("must be no more than %d characters.", NamingPolicy.MaxNameLength)))
+	}
+
+	// Nginxリソース名は"controller"のLabelの値として使用する
+	for _, msg := range validation.IsValidLabelValue(r.Name) {
+		errs = append(errs, field.Invalid(path, r.Name, msg))
+	}
+
+	// 実際に生成される管理リソースの名前を確認する
+	generated := []struct {
+		kind string
+		name string
+		fn   func(string) []string
+	}{
+		{"Deployment", NamingPolicy.Deployment(r.Name), validation.IsDNS1123Subdomain},
+		{"Service", NamingPolicy.Service(r.Name), validation.IsDNS1035Label},
+		{"ConfigMap", NamingPolicy.ConfigMap(r.Name), validation.IsDNS1123Subdomain},
+		// JobのPodには"job-name"のLabelとして付与される
+		{"Job", NamingPolicy.ConfigCheckJob(r.Name), validation.IsDNS1123Label},
+		{"PodDisruptionBudget", NamingPolicy.PodDisruptionBudget(r.Name), validation.IsDNS1123Subdomain},
+		{"NetworkPolicy", NamingPolicy.NetworkPolicy(r.Name), validation.IsDNS1123Subdomain},
+		{"ServiceAccount", NamingPolicy.ServiceAccount(r.Name), validation.IsDNS1123Subdomain},
+		// ControllerRevisionはspecのハッシュ値を付与した名前になる
+		{"ControllerRevision", NamingPolicy.Revision(r.Name, strings.Repeat("0", 10)), validation.IsDNS1123Subdomain},
+	}
+	for _, g := range generated {
+		for _, msg := range g.fn(g.name) {
+			errs = append(errs, field.Invalid(path, r.Name, fmt.Sprintf("generated %s name %q is invalid: %s", g.kind, g.name, msg)))
+		}
+	}
+
+	return errs
+}
+
+// spec.config.contentをLintするメソッド
+// 問題のある行ごとにspec.config.contentの行番号を含むエラーを返す
+func (r *Nginx) validateNginxConfig() field.ErrorList {
+	if r.Spec.Config == nil {
+		return nil
+	}
+
+	nginxlog.Info("[Validation] Lint Nginx config", "name", r.Name)
+
+	var errs field.ErrorList
+
+	path := field.NewPath("spec").Child("config").Child("content")
+	lines := strings.Split(r.Spec.Config.Content, "\n")
+	// conf.d配下に配置されるのでhttpコンテキストとしてLintする
+	for _, issue := range nginxconf.Lint(r.Spec.Config.Content, nginxconf.ContextHTTP) {
+		value := ""
+		if issue.Line > 0 && issue.Line <= len(lines) {
+			value = strings.TrimSpace(lines[issue.Line-1])
+		}
+		errs = append(errs, field.Invalid(path, value, issue.String()))
+	}
+
+	return errs
+}
+
+// NginxClassの制約を満たしているかを確認するメソッド
+func (r *Nginx) validateNginxClass(class *NginxClass) field.ErrorList {
+	if r.Spec.NginxClassName == "" {
+		return nil
+	}
+
+	nginxlog.Info("[Validation] Check NginxClass constraints", "name", r.Name)
+
+	var errs field.ErrorList
+
+	spec := field.NewPath("spec")
+	if class == nil {
+		return append(errs, field.NotFound(spec.Child("nginxClassName"), r.Spec.NginxClassName))
+	}
+	constraints := class.Spec.Constraints
+
+	if len(constraints.AllowedServiceTypes) > 0 && !containsServiceType(constraints.AllowedServiceTypes, r.Spec.Service.Type) {
+		errs = append(errs, field.NotSupported(spec.Child("service").Child("type"), r.Spec.Service.Type, serviceTypeStrings(constraints.AllowedServiceTypes)))
+	}
+
+	if constraints.MaxReplicas != nil && r.Spec.Replicas != nil && *r.Spec.Replicas > *constraints.MaxReplicas {
+		errs = append(errs, field.Invalid(spec.Child("replicas"), *r.Spec.Replicas, fmt.Sprintf("must be no more than %d in NginxClass %s", *constraints.MaxReplicas, class.Name)))
+	}
+
+	if len(constraints.AllowedImageRegistries) > 0 && !imageAllowed(r.Spec.Image, constraints.AllowedImageRegistries) {
+		errs = append(errs, field.Invalid(spec.Child("image"), r.Spec.Image, "registry is not allowed in NginxClass "+class.Name+": allowed registries are "+strings.Join(constraints.AllowedImageRegistries, ", ")))
+	}
+
+	return errs
+}
+
+func containsServiceType(types []corev1.ServiceType, t corev1.ServiceType) bool {
+	for _, allowed := range types {
+		if allowed == t {
+			return true
+		}
+	}
+	return false
+}
+
+func serviceTypeStrings(types []corev1.ServiceType) []string {
+	var s []string
+	for _, t := range types {
+		s = append(s, string(t))
+	}
+	return s
+}
+
+// ImageがallowedのいずれかのRegistryのものかを確認する
+// Registryを省略したImage(例: "nginx:latest")はDocker Hub("docker.io/library/nginx:latest")として扱う
+func imageAllowed(image string, allowed []string) bool {
+	image = normalizeImage(image)
+	for _, registry := range allowed {
+		if strings.HasPrefix(image, strings.TrimSuffix(registry, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ImageをRegistryを含む名前に変換する
+func normalizeImage(image string) string {
+	first, rest, found := strings.Cut(image, "/")
+	if !found {
+		return "docker.io/library/" + image
+	}
+	// 最初の要素に"."か":"を含むか"localhost"の場合はRegistryとみなす
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return image
+	}
+	return "docker.io/" + first + "/" + rest
+}
@@ -0,0 +1,292 @@
+package v2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"example.com/nginx-controller/pkg/features"
+)
+
+var _ = Describe("Nginx Webhook", func() {
+
+	// Mutationのテスト
+	Context("Mutation", func() {
+		It("Should mutate a Nginx", func() {
+			mutateTest(filepath.Join("testdata", "mutate", "before.yaml"), filepath.Join("testdata", "mutate", "after.yaml"))
+		})
+		It("Should replace the builtin defaults when hardened is enabled later", func() {
+			ctx := context.Background()
+			validateTest(filepath.Join("testdata", "mutate", "before-hardened.yaml"), true)
+
+			nginx := &Nginx{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: "nginx-harden-later", Namespace: "default"}, nginx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*nginx.Spec.Port).To(Equal(DefaultPort))
+			Expect(nginx.Spec.Image).To(Equal(DefaultImage))
+
+			// 作成時に保存された80番Portとrootで動作するImageはhardenedのデフォルト値に置き換わること
+			nginx.Spec.SecurityContext = &SecurityContextSpec{Hardened: true}
+			Expect(k8sClient.Update(ctx, nginx)).To(Succeed())
+			Expect(*nginx.Spec.Port).To(Equal(HardenedPort))
+			Expect(nginx.Spec.Image).To(Equal(HardenedImage))
+		})
+	})
+
+	// Validationのテスト
+	Context("Validation", func() {
+		It("Should create a valid Nginx", func() {
+			validateTest(filepath.Join("testdata", "validate", "valid.yaml"), true)
+		})
+		It("Should not create a invalid Nginx", func() {
+			validateTest(filepath.Join("testdata", "validate", "invalid.yaml"), false)
+		})
+		It("Should not create a Nginx with invalid config", func() {
+			validateTest(filepath.Join("testdata", "validate", "invalid-config.yaml"), false)
+		})
+		It("Should not create a Nginx with LoadBalancer settings on a ClusterIP Service", func() {
+			validateTest(filepath.Join("testdata", "validate", "invalid-service.yaml"), false,
+				"spec.service.externalTrafficPolicy: Forbidden", "spec.service.loadBalancerSourceRanges: Forbidden")
+		})
+		It("Should create a Nginx with a disruption budget allowing evictions", func() {
+			validateTest(filepath.Join("testdata", "validate", "valid-pdb.yaml"), true)
+		})
+		It("Should not create a Nginx with a disruption budget blocking evictions", func() {
+			validateTest(filepath.Join("testdata", "validate", "invalid-pdb.yaml"), false, "spec.disruptionBudget.minAvailable: Invalid value")
+		})
+		It("Should create a Nginx with a network policy", func() {
+			validateTest(filepath.Join("testdata", "validate", "valid-networkpolicy.yaml"), true)
+		})
+		It("Should not create a Nginx with an invalid network policy", func() {
+			validateTest(filepath.Join("testdata", "validate", "invalid-networkpolicy.yaml"), false)
+		})
+		It("Should not create a hardened Nginx that cannot run as non-root", func() {
+			validateTest(filepath.Join("testdata", "validate", "invalid-hardened.yaml"), false,
+				"spec.port: Invalid value: 80", "spec.securityContext.container.privileged: Forbidden")
+		})
+		It("Should not create a Nginx with an existing ServiceAccount without name", func() {
+			validateTest(filepath.Join("testdata", "validate", "invalid-serviceaccount.yaml"), false,
+				"spec.serviceAccount.name: Required value", "spec.serviceAccount.annotations: Forbidden")
+		})
+		It("Should create a Nginx with a sidecar and volumes", func() {
+			validateTest(filepath.Join("testdata", "validate", "valid-podtemplate.yaml"), true)
+		})
+		It("Should not create a Nginx whose pod template overrides the controller", func() {
+			validateTest(filepath.Join("testdata", "validate", "invalid-podtemplate.yaml"), false,
+				"spec.podTemplate.labels[controller]: Forbidden",
+				`spec.podTemplate.volumeMounts[0].name: Not found: "missing"`,
+				"spec.podTemplate.containers[0].name: Forbidden",
+				"spec.podTemplate.containers[0].ports[0].containerPort: Invalid value: 80",
+				// hardenedでない場合はtmpのVolumeは作成されない
+				`spec.podTemplate.containers[1].volumeMounts[0].name: Not found: "tmp"`)
+		})
+		It("Should not create a Nginx with an invalid env", func() {
+			validateTest(filepath.Join("testdata", "validate", "invalid-env.yaml"), false)
+		})
+		It("Should not create a Nginx whose port collides with the reloader", func() {
+			validateTest(filepath.Join("testdata", "validate", "invalid-port.yaml"), false)
+		})
+		It("Should not update an immutable field", func() {
+			validateTest(filepath.Join("testdata", "validate", "immutable-before.yaml"), true)
+
+			nginx := &Nginx{}
+			err := k8sClient.Get(context.Background(), types.NamespacedName{Name: "nginx-immutable", Namespace: "default"}, nginx)
+			Expect(err).NotTo(HaveOccurred())
+
+			lbClass := "example.com/external"
+			nginx.Spec.Service.LoadBalancerClass = &lbClass
+			err = k8sClient.Update(context.Background(), nginx)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "error: %v", err)
+		})
+	})
+
+	// 削除保護のテスト
+	Context("Deletion protection", func() {
+		It("Should not delete a Nginx until the protection annotation is removed", func() {
+			ctx := context.Background()
+			validateTest(filepath.Join("testdata", "validate", "protected.yaml"), true)
+
+			nginx := &Nginx{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: "nginx-protected", Namespace: "default"}, nginx)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = k8sClient.Delete(ctx, nginx)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("protected from deletion"))
+
+			delete(nginx.Annotations, DeletionProtectionAnnotation)
+			Expect(k8sClient.Update(ctx, nginx)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, nginx)).To(Succeed())
+		})
+	})
+
+	// NginxClassのテスト
+	Context("NginxClass", func() {
+		BeforeEach(func() {
+			createNginxClass(filepath.Join("testdata", "class", "nginxclass.yaml"))
+		})
+		It("Should fill defaults from the NginxClass", func() {
+			mutateTest(filepath.Join("testdata", "mutate", "before-class.yaml"), filepath.Join("testdata", "mutate", "after-class.yaml"))
+		})
+		It("Should create a Nginx satisfying the NginxClass constraints", func() {
+			validateTest(filepath.Join("testdata", "validate", "valid-class.yaml"), true)
+		})
+		It("Should not create a Nginx violating the NginxClass constraints", func() {
+			validateTest(filepath.Join("testdata", "validate", "invalid-class.yaml"), false,
+				"spec.service.type: Unsupported value", "spec.replicas: Invalid value: 10", "spec.image: Invalid value")
+		})
+		It("Should not create a Nginx referring to a missing NginxClass", func() {
+			validateTest(filepath.Join("testdata", "validate", "missing-class.yaml"), false, `spec.nginxClassName: Not found: "not-found"`)
+		})
+	})
+
+	// Feature Gateのテスト
+	Context("Feature gates", func() {
+		It("Should not create a Nginx using a disabled feature", func() {
+			Expect(features.Set("BlueGreen=false")).To(Succeed())
+			DeferCleanup(features.Set, "BlueGreen=true")
+
+			y, err := os.ReadFile(filepath.Join("testdata", "validate", "feature-gated.yaml"))
+			Expect(err).NotTo(HaveOccurred())
+			nginx := &Nginx{}
+			Expect(yaml.NewYAMLOrJSONDecoder(bytes.NewBuffer(y), 4096).Decode(nginx)).To(Succeed())
+
+			// 無効なFeature Gateの名前をエラーメッセージに含むこと
+			err = k8sClient.Create(context.Background(), nginx)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "error: %v", err)
+			Expect(err.Error()).To(ContainSubstring("requires the BlueGreen feature gate"))
+
+			Expect(features.Set("BlueGreen=true")).To(Succeed())
+			Expect(k8sClient.Create(context.Background(), nginx)).To(Succeed())
+		})
+
+		It("Should not accept the rollback annotation when SpecRollback is disabled", func() {
+			Expect(features.Set("SpecRollback=false")).To(Succeed())
+			DeferCleanup(features.Set, "SpecRollback=true")
+
+			nginx := &Nginx{}
+			nginx.Name = "nginx-rollback-gated"
+			nginx.Namespace = "default"
+			nginx.Annotations = map[string]string{RollbackToAnnotation: "1"}
+
+			err := k8sClient.Create(context.Background(), nginx)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "error: %v", err)
+			Expect(err.Error()).To(ContainSubstring("metadata.annotations[nginx.my.domain/rollback-to]: Forbidden: requires the SpecRollback feature gate"))
+		})
+	})
+})
+
+// テスト用のNginxClassを作成する関数(既に存在する場合は何もしない)
+func createNginxClass(file string) {
+	ctx := context.Background()
+
+	y, err := os.ReadFile(file)
+	Expect(err).NotTo(HaveOccurred())
+
+	d := yaml.NewYAMLOrJSONDecoder(bytes.NewBuffer(y), 4096)
+	class := &NginxClass{}
+	err = d.Decode(class)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = k8sClient.Create(ctx, class)
+	if !apierrors.IsAlreadyExists(err) {
+		Expect(err).NotTo(HaveOccurred())
+	}
+}
+
+// Mutationテスト用の関数
+func mutateTest(before string, after string) {
+	ctx := context.Background()
+
+	// ファイルをByte型配列として読み込む
+	y, err := os.ReadFile(before)
+	Expect(err).NotTo(HaveOccurred())
+
+	// 読み取ったYAMLの内容を&Nginx型に変換する
+	d := yaml.NewYAMLOrJSONDecoder(bytes.NewBuffer(y), 4096)
+	beforeNginx := &Nginx{}
+	err = d.Decode(beforeNginx)
+	Expect(err).NotTo(HaveOccurred())
+
+	// Nginxリソースを作成
+	err = k8sClient.Create(ctx, beforeNginx)
+	Expect(err).NotTo(HaveOccurred())
+
+	// 作成したNginxリソースを取得
+	ret := &Nginx{}
+	err = k8sClient.Get(ctx, types.NamespacedName{Name: beforeNginx.GetName(), Namespace: beforeNginx.GetNamespace()}, ret)
+	Expect(err).NotTo(HaveOccurred())
+
+	y, err = os.ReadFile(after)
+	Expect(err).NotTo(HaveOccurred())
+
+	d = yaml.NewYAMLOrJSONDecoder(bytes.NewBuffer(y), 4096)
+	afterNginx := &Nginx{}
+	err = d.Decode(afterNginx)
+	Expect(err).NotTo(HaveOccurred())
+
+	// 実際に作成されたNginxとafterのannotationsを比較する
+	Expect(ret.ObjectMeta.Annotations).Should(Equal(afterNginx.ObjectMeta.Annotations))
+
+	// 実際に作成されたNginxとafterのデフォルト値が設定されるspecを比較する
+	Expect(ret.Spec.Image).Should(Equal(afterNginx.Spec.Image))
+	Expect(ret.Spec.Port).Should(Equal(afterNginx.Spec.Port))
+	Expect(ret.Spec.ReadinessProbe).Should(Equal(afterNginx.Spec.ReadinessProbe))
+	Expect(ret.Spec.LivenessProbe).Should(Equal(afterNginx.Spec.LivenessProbe))
+}
+
+// Validationテスト用の関数
+//
+//	第1引数: 適用するYAML
+//	第2引数: 適用時のvalidation期待値
+//
+// 失敗する場合はexpected(未指定の場合は"Invalid value")がエラーメッセージに全て含まれることを確認する
+func validateTest(file string, valid bool, expected ...string) {
+	ctx := context.Background()
+
+	// ファイルをByte型配列として読み込む
+	y, err := os.ReadFile(file)
+	Expect(err).NotTo(HaveOccurred())
+
+	// 読み取ったYAMLの内容を&Nginx型に変換する
+	d := yaml.NewYAMLOrJSONDecoder(bytes.NewBuffer(y), 4096)
+	nginx := &Nginx{}
+	err = d.Decode(nginx)
+	Expect(err).NotTo(HaveOccurred())
+
+	// Nginxリソースを作成
+	err = k8sClient.Create(ctx, nginx)
+
+	if valid {
+		Expect(err).NotTo(HaveOccurred(), "Nginx: %v", nginx)
+	} else {
+		// errが値を持っていること
+		Expect(err).To(HaveOccurred(), "Nginx: %v", nginx)
+
+		// metav1.Status構造体を初期化
+		// Validation Webhookは失敗するとerrors.StatusError型のエラーを返す
+		statusErr := &apierrors.StatusError{}
+		// metav1.Statusに該当するフィールドがerrに含まれていることを確認
+		Expect(errors.As(err, &statusErr)).To(BeTrue())
+
+		// エラーメッセージの期待値を設定
+		if len(expected) == 0 {
+			expected = []string{"Invalid value"}
+		}
+
+		// エラーレスポンスに含まれるmetav1.Status.Messageに期待値が含まれることを確認
+		for _, e := range expected {
+			Expect(statusErr.ErrStatus.Message).To(ContainSubstring(e))
+		}
+
+	}
+
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// spec.nginxClassNameが未指定のNginxに使用するNginxClassに付与するAnnotation("true"でデフォルト)
+	DefaultClassAnnotation = "nginx.my.domain/is-default-class"
+)
+
+// NginxClassSpec defines the defaults and constraints applied to Nginx of the class
+type NginxClassSpec struct {
+	// Nginxのspecに指定されていない場合に設定するデフォルト値
+	// +optional
+	Defaults NginxClassDefaults `json:"defaults,omitempty"`
+
+	// Nginxのspecに対する制約
+	// +optional
+	Constraints NginxClassConstraints `json:"constraints,omitempty"`
+}
+
+// NginxClassDefaults defines the default values of NginxSpec
+type NginxClassDefaults struct {
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+
+	// +optional
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// +optional
+	ReadinessProbe *corev1.Probe `json:"readinessProbe,omitempty"`
+
+	// +optional
+	LivenessProbe *corev1.Probe `json:"livenessProbe,omitempty"`
+}
+
+// NginxClassConstraints defines the constraints enforced on Nginx of the class
+type NginxClassConstraints struct {
+	// 使用できるServiceのType(未指定の場合は制限しない)
+	// +optional
+	AllowedServiceTypes []corev1.ServiceType `json:"allowedServiceTypes,omitempty"`
+
+	// 指定できるReplicasの上限(未指定の場合は制限しない)
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxReplicas *int32 `json:"maxReplicas,omitempty"`
+
+	// 使用できるImageのRegistry(例: "registry.example.com", "docker.io/library")
+	// Imageが"<Registry>/"で始まる場合に許可する(未指定の場合は制限しない)
+	// +optional
+	AllowedImageRegistries []string `json:"allowedImageRegistries,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:resource:shortName="ngc"
+// +kubebuilder:printcolumn:JSONPath=".metadata.annotations.nginx\\.my\\.domain/is-default-class",name=Default,type=string
+// +kubebuilder:printcolumn:JSONPath=".metadata.creationTimestamp",name=Age,type=date
+
+// NginxClass is the Schema for the nginxclasses API
+type NginxClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NginxClassSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NginxClassList contains a list of NginxClass
+type NginxClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NginxClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NginxClass{}, &NginxClassList{})
+}
+
+// Nginxのspecのデフォルト値としてNginxSpecに変換する
+func (d *NginxClassDefaults) nginxSpec() *NginxSpec {
+	return &NginxSpec{
+		Replicas:       d.Replicas,
+		Image:          d.Image,
+		Port:           d.Port,
+		Service:        ServiceSpec{Type: d.ServiceType},
+		Resources:      d.Resources,
+		ReadinessProbe: d.ReadinessProbe,
+		LivenessProbe:  d.LivenessProbe,
+	}
+}
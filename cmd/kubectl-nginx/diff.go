@@ -0,0 +1,201 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"example.com/nginx-controller/controllers"
+	"example.com/nginx-controller/pkg/render"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// diff: ローカルのマニフェストから生成した子リソースとクラスタ上のリソースの差分を表示する
+// kubectl diffと同様にKUBECTL_EXTERNAL_DIFFで差分を表示するコマンドを変更でき、差分がある場合は終了コード1を返す
+func runDiff(ctx context.Context, args []string) error {
+	var opts kubeOptions
+	var manifest manifestOptions
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	opts.bind(fs)
+	manifest.bind(fs)
+	if positional, err := parseArgs(fs, args); err != nil {
+		return err
+	} else if len(positional) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", positional)
+	}
+
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+	r, err := manifest.reconciler()
+	if err != nil {
+		return err
+	}
+	nginxes, err := manifest.load(namespace)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "kubectl-nginx-diff-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	liveDir := filepath.Join(dir, "LIVE")
+	mergedDir := filepath.Join(dir, "MERGED")
+	for _, d := range []string{liveDir, mergedDir} {
+		if err := os.Mkdir(d, 0o700); err != nil {
+			return err
+		}
+	}
+
+	for _, nginx := range nginxes {
+		if err := writeDiffFiles(ctx, c, r.Render, nginx, liveDir, mergedDir); err != nil {
+			return err
+		}
+	}
+
+	return runDiffProgram(liveDir, mergedDir)
+}
+
+// 差分が見つかったことを表すエラー(メッセージは出力せず終了コード1で終了する)
+var errDiffFound = errors.New("differences found")
+
+type renderFunc func(nginx *nginxv2.Nginx, live ...client.Object) ([]client.Object, error)
+
+// Nginxの子リソースについてクラスタ上の状態をliveDirに、Controllerが更新した後の状態をmergedDirに書き出す
+func writeDiffFiles(ctx context.Context, c client.Client, render renderFunc, nginx *nginxv2.Nginx, liveDir, mergedDir string) error {
+	// blue/greenのactiveColorなどstatusに依存する値はクラスタ上のNginxから引き継ぐ
+	var current nginxv2.Nginx
+	if err := c.Get(ctx, client.ObjectKeyFromObject(nginx), &current); err == nil {
+		nginx.UID = current.UID
+		nginx.Status = current.Status
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	// 生成されるオブジェクトの名前でクラスタ上のオブジェクトを取得する
+	desired, err := render(nginx)
+	if err != nil {
+		return err
+	}
+	var live []client.Object
+	var referenced []client.Object // 生成に使用するが差分には含めないオブジェクト
+	for _, obj := range desired {
+		obj := obj.DeepCopyObject().(client.Object)
+		if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		live = append(live, obj)
+	}
+
+	// NetworkPolicyのEgressを生成するためにupstreamのServiceを取得する(差分の表示には含めない)
+	if nginx.Spec.NetworkPolicy != nil {
+		for _, upstream := range nginx.Spec.NetworkPolicy.Upstreams {
+			if upstream.Service == nil {
+				continue
+			}
+			service := &corev1.Service{}
+			key := client.ObjectKey{Namespace: upstream.Service.Namespace, Name: upstream.Service.Name}
+			if key.Namespace == "" {
+				key.Namespace = nginx.Namespace
+			}
+			if err := c.Get(ctx, key, service); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+			referenced = append(referenced, service)
+		}
+	}
+
+	// Podのenv-checksumを計算するためにspec.env/envFromで参照しているConfigMap/Secretを取得する(差分の表示には含めない)
+	for _, obj := range controllers.EnvSourceObjects(nginx) {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		referenced = append(referenced, obj)
+	}
+
+	merged, err := render(nginx, append(live, referenced...)...)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range live {
+		if err := writeDiffFile(liveDir, obj); err != nil {
+			return err
+		}
+	}
+	for _, obj := range merged {
+		if err := writeDiffFile(mergedDir, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kubectl diffと同じ"<group>.<version>.<kind>.<namespace>.<name>"の形式のファイル名で書き出す
+func writeDiffFile(dir string, obj client.Object) error {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	name := strings.Join([]string{gvk.Group, gvk.Version, gvk.Kind, obj.GetNamespace(), obj.GetName()}, ".")
+	name = strings.TrimPrefix(name, ".")
+
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return render.WriteYAML(f, []client.Object{obj})
+}
+
+// 差分を表示し、差分がある場合はerrDiffFoundを返す
+func runDiffProgram(liveDir, mergedDir string) error {
+	program := []string{"diff", "-u", "-N"}
+	if external := os.Getenv("KUBECTL_EXTERNAL_DIFF"); external != "" {
+		program = strings.Fields(external)
+	}
+
+	cmd := exec.Command(program[0], append(program[1:], liveDir, mergedDir)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return errDiffFound
+	}
+	return err
+}
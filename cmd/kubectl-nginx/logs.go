@@ -0,0 +1,105 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// logs: Nginxが管理する全てのPodのnginxコンテナのログをPod名を付けて表示する
+func runLogs(ctx context.Context, args []string) error {
+	var opts kubeOptions
+	var follow bool
+	var tail int64
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	opts.bind(fs)
+	fs.BoolVar(&follow, "follow", false, "Stream the logs.")
+	fs.BoolVar(&follow, "f", false, "Shorthand for --follow.")
+	fs.Int64Var(&tail, "tail", -1, "Lines of recent log to display for each pod. Defaults to all lines.")
+	name, err := nginxNameArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	cs, namespace, err := opts.clientset()
+	if err != nil {
+		return err
+	}
+
+	// Controllerが全てのPodに付与するLabel(blue/greenの場合は両方のcolor)
+	selector := labels.SelectorFromSet(labels.Set{"app": "nginx", "controller": name})
+	pods, err := cs.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Errorf("no pods found for nginx %s in namespace %s", name, namespace)
+	}
+
+	logOptions := &corev1.PodLogOptions{Container: "nginx", Follow: follow}
+	if tail >= 0 {
+		logOptions.TailLines = &tail
+	}
+
+	// 複数のPodの行が混ざらないように1行ずつ書き出す
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(chan error, len(pods.Items))
+	for _, pod := range pods.Items {
+		wg.Add(1)
+		go func(podName string) {
+			defer wg.Done()
+			stream, err := cs.CoreV1().Pods(namespace).GetLogs(podName, logOptions).Stream(ctx)
+			if err != nil {
+				errs <- fmt.Errorf("pod %s: %w", podName, err)
+				return
+			}
+			defer stream.Close()
+			if err := copyLines(os.Stdout, &mu, "["+podName+"] ", stream); err != nil {
+				errs <- fmt.Errorf("pod %s: %w", podName, err)
+			}
+		}(pod.Name)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		fmt.Fprintln(os.Stderr, "error:", err)
+	}
+	return nil
+}
+
+// inの各行の先頭にprefixを付けてoutに書き出す
+func copyLines(out io.Writer, mu *sync.Mutex, prefix string, in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		mu.Lock()
+		fmt.Fprintln(out, prefix+scanner.Text())
+		mu.Unlock()
+	}
+	return scanner.Err()
+}
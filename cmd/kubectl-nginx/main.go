@@ -0,0 +1,200 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-nginx はNginxリソースを操作するkubectlのプラグイン
+// PATHに配置すると"kubectl nginx <command>"として実行できる
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	nginxv1 "example.com/nginx-controller/api/v1"
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(nginxv1.AddToScheme(scheme))
+	utilruntime.Must(nginxv2.AddToScheme(scheme))
+}
+
+// サブコマンドの定義
+type command struct {
+	usage string
+	short string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"status":   {usage: "status NAME", short: "Show conditions, pods and addresses of a Nginx", run: runStatus},
+	"reload":   {usage: "reload NAME", short: "Restart the pods of a Nginx with a rolling update", run: runReload},
+	"rollback": {usage: "rollback NAME [--to-revision=N]", short: "Roll back a Nginx to a previous spec revision", run: runRollback},
+	"render":   {usage: "render -f FILE", short: "Print the child resources generated for a local Nginx manifest", run: runRender},
+	"logs":     {usage: "logs NAME [-f] [--tail=N]", short: "Print the nginx logs of all pods of a Nginx", run: runLogs},
+	"diff":     {usage: "diff -f FILE", short: "Diff the live resources against the ones generated for a local Nginx manifest", run: runDiff},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage(os.Stderr)
+		os.Exit(1)
+	}
+
+	name := os.Args[1]
+	if name == "-h" || name == "--help" || name == "help" {
+		printUsage(os.Stdout)
+		return
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(1)
+	}
+
+	if err := cmd.run(context.Background(), os.Args[2:]); err != nil {
+		switch err {
+		case flag.ErrHelp:
+			return
+		case errDiffFound:
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: kubectl nginx <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-34s %s\n", commands[name].usage, commands[name].short)
+	}
+}
+
+// 全てのサブコマンドに共通のkubeconfigに関するflag
+type kubeOptions struct {
+	kubeconfig string
+	context    string
+	namespace  string
+}
+
+func (o *kubeOptions) bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	fs.StringVar(&o.context, "context", "", "The name of the kubeconfig context to use.")
+	fs.StringVar(&o.namespace, "namespace", "", "The namespace of the Nginx. Defaults to the namespace of the current context.")
+	fs.StringVar(&o.namespace, "n", "", "Shorthand for --namespace.")
+}
+
+func (o *kubeOptions) clientConfig() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.context}
+	if o.namespace != "" {
+		overrides.Context.Namespace = o.namespace
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
+
+// kubeconfigからREST Configと対象のNamespaceを取得する
+func (o *kubeOptions) restConfig() (*rest.Config, string, error) {
+	cc := o.clientConfig()
+	config, err := cc.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	namespace, _, err := cc.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	return config, namespace, nil
+}
+
+// Nginxリソースを扱うcontroller-runtimeのClientを作成する
+func (o *kubeOptions) client() (client.Client, string, error) {
+	config, namespace, err := o.restConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, "", err
+	}
+	return c, namespace, nil
+}
+
+// Podのログを取得するためのclient-goのClientsetを作成する
+func (o *kubeOptions) clientset() (kubernetes.Interface, string, error) {
+	config, namespace, err := o.restConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	cs, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, "", err
+	}
+	return cs, namespace, nil
+}
+
+// flagと引数が混在していても解析できるようにする(例: "status NAME -n default")
+// flagパッケージは最初の引数でflagの解析を止めるため、引数を取り出しながら繰り返し解析する
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// 引数としてNginxの名前を1つだけ受け取る
+func nginxNameArg(fs *flag.FlagSet, args []string) (string, error) {
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return "", err
+	}
+	if len(positional) != 1 {
+		return "", fmt.Errorf("exactly one Nginx name is required, got %d arguments: %s", len(positional), strings.Join(positional, " "))
+	}
+	return positional[0], nil
+}
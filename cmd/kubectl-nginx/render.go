@@ -0,0 +1,116 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"example.com/nginx-controller/controllers"
+	"example.com/nginx-controller/pkg/features"
+	"example.com/nginx-controller/pkg/naming"
+	"example.com/nginx-controller/pkg/render"
+)
+
+// render/diffで読み込むNginxのマニフェストに関するflag
+type manifestOptions struct {
+	filename            string
+	naming              naming.Policy
+	controllerNamespace string
+	featureGates        string
+}
+
+func (o *manifestOptions) bind(fs *flag.FlagSet) {
+	o.naming = naming.Default()
+	fs.StringVar(&o.filename, "filename", "", "The file that contains the Nginx manifests (v1 or v2). Use - for stdin.")
+	fs.StringVar(&o.filename, "f", "", "Shorthand for --filename.")
+	fs.StringVar(&o.naming.DeploymentTemplate, "deployment-name-template", naming.DefaultDeploymentTemplate,
+		"The template of the Deployment names, same as the flag of the controller.")
+	fs.StringVar(&o.naming.ServiceTemplate, "service-name-template", naming.DefaultServiceTemplate,
+		"The template of the Service names, same as the flag of the controller.")
+	fs.StringVar(&o.controllerNamespace, "controller-namespace", "nginx-controller-system",
+		"The namespace of the controller, allowed to connect to the reloader sidecars by NetworkPolicies.")
+	fs.StringVar(&o.naming.ConfigMapTemplate, "configmap-name-template", naming.DefaultConfigMapTemplate,
+		"The template of the ConfigMap names, same as the flag of the controller.")
+	fs.StringVar(&o.naming.PodDisruptionBudgetTemplate, "pdb-name-template", naming.DefaultPodDisruptionBudgetTemplate,
+		"The template of the PodDisruptionBudget names, same as the flag of the controller.")
+	fs.StringVar(&o.naming.NetworkPolicyTemplate, "networkpolicy-name-template", naming.DefaultNetworkPolicyTemplate,
+		"The template of the NetworkPolicy names, same as the flag of the controller.")
+	fs.StringVar(&o.naming.ServiceAccountTemplate, "serviceaccount-name-template", naming.DefaultServiceAccountTemplate,
+		"The template of the ServiceAccount names, same as the flag of the controller.")
+	fs.StringVar(&o.featureGates, "feature-gates", "",
+		"The feature gates enabled in the controller (e.g. \"BlueGreen=true\"), same as the flag of the controller.")
+}
+
+// Controllerと同じmutate関数で子リソースを生成するReconciler(Clientは使用しない)
+func (o *manifestOptions) reconciler() (*controllers.NginxReconciler, error) {
+	if err := o.naming.Validate(); err != nil {
+		return nil, err
+	}
+	if err := features.Set(o.featureGates); err != nil {
+		return nil, err
+	}
+	return &controllers.NginxReconciler{Scheme: scheme, Naming: o.naming, ControllerNamespace: o.controllerNamespace}, nil
+}
+
+// ファイルからNginxを読み込む
+func (o *manifestOptions) load(namespace string) ([]*nginxv2.Nginx, error) {
+	if o.filename == "" {
+		return nil, errors.New("--filename is required")
+	}
+	return render.ReadFile(o.filename, scheme, namespace)
+}
+
+// render: ローカルのマニフェストからControllerが作成する子リソースを出力する
+// クラスタには接続しない
+func runRender(ctx context.Context, args []string) error {
+	var opts manifestOptions
+	var namespace string
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	opts.bind(fs)
+	fs.StringVar(&namespace, "namespace", "default", "The namespace of the Nginx without metadata.namespace.")
+	fs.StringVar(&namespace, "n", "default", "Shorthand for --namespace.")
+	if positional, err := parseArgs(fs, args); err != nil {
+		return err
+	} else if len(positional) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", positional)
+	}
+
+	r, err := opts.reconciler()
+	if err != nil {
+		return err
+	}
+	nginxes, err := opts.load(namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, nginx := range nginxes {
+		objs, err := r.Render(nginx)
+		if err != nil {
+			return err
+		}
+		if err := render.WriteYAML(os.Stdout, objs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reload: Annotationを更新してControllerにPodを順に再作成させる(kubectl rollout restartと同様)
+func runReload(ctx context.Context, args []string) error {
+	var opts kubeOptions
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	opts.bind(fs)
+	name, err := nginxNameArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	var nginx nginxv2.Nginx
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &nginx); err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(nginx.DeepCopy())
+	if nginx.Annotations == nil {
+		nginx.Annotations = make(map[string]string)
+	}
+	nginx.Annotations[nginxv2.RestartedAtAnnotation] = time.Now().Format(time.RFC3339)
+	if err := c.Patch(ctx, &nginx, patch); err != nil {
+		return err
+	}
+
+	fmt.Printf("nginx.nginx.my.domain/%s restarted\n", name)
+	return nil
+}
+
+// rollback: spec.rollbackToを設定してControllerに保存済みのspecへ戻させる
+func runRollback(ctx context.Context, args []string) error {
+	var opts kubeOptions
+	var toRevision int64
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	opts.bind(fs)
+	fs.Int64Var(&toRevision, "to-revision", 0, "The revision to roll back to. Defaults to the revision before the current one.")
+	name, err := nginxNameArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	var nginx nginxv2.Nginx
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &nginx); err != nil {
+		return err
+	}
+
+	if toRevision == 0 {
+		toRevision = previousRevision(&nginx)
+		if toRevision == 0 {
+			return fmt.Errorf("no previous revision of nginx %s to roll back to", name)
+		}
+	}
+	if !hasRevision(&nginx, toRevision) {
+		return fmt.Errorf("revision %d of nginx %s not found", toRevision, name)
+	}
+
+	patch := client.MergeFrom(nginx.DeepCopy())
+	nginx.Spec.RollbackTo = &toRevision
+	if err := c.Patch(ctx, &nginx, patch); err != nil {
+		return err
+	}
+
+	fmt.Printf("nginx.nginx.my.domain/%s rolled back to revision %s\n", name, strconv.FormatInt(toRevision, 10))
+	return nil
+}
+
+// status.currentRevisionより前の最新のrevision(存在しない場合は0)
+func previousRevision(nginx *nginxv2.Nginx) int64 {
+	var previous int64
+	for _, r := range nginx.Status.Revisions {
+		if r.Revision < nginx.Status.CurrentRevision && r.Revision > previous {
+			previous = r.Revision
+		}
+	}
+	return previous
+}
+
+func hasRevision(nginx *nginxv2.Nginx, revision int64) bool {
+	for _, r := range nginx.Status.Revisions {
+		if r.Revision == revision {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// status: Nginxのstatusを表示する
+func runStatus(ctx context.Context, args []string) error {
+	var opts kubeOptions
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	opts.bind(fs)
+	name, err := nginxNameArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	var nginx nginxv2.Nginx
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &nginx); err != nil {
+		return err
+	}
+
+	printStatus(os.Stdout, &nginx, time.Now())
+	return nil
+}
+
+func printStatus(out io.Writer, nginx *nginxv2.Nginx, now time.Time) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	status := nginx.Status
+	fmt.Fprintf(w, "Name:\t%s\n", nginx.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", nginx.Namespace)
+	fmt.Fprintf(w, "Image:\t%s\n", nginx.Spec.Image)
+
+	strategy := string(nginx.Spec.Strategy.Type)
+	if strategy == "" {
+		strategy = string(nginxv2.RollingUpdateStrategyType)
+	}
+	if status.ActiveColor != "" {
+		strategy += " (active: " + status.ActiveColor + ")"
+	}
+	fmt.Fprintf(w, "Strategy:\t%s\n", strategy)
+	fmt.Fprintf(w, "Deployment:\t%s (%d available)\n", status.DeploymentName, status.AvailableReplicas)
+	fmt.Fprintf(w, "Service:\t%s\n", status.ServiceName)
+	if status.PreviewServiceName != "" {
+		fmt.Fprintf(w, "Preview Service:\t%s\n", status.PreviewServiceName)
+	}
+	fmt.Fprintf(w, "Cluster IP:\t%s\n", valueOrNone(status.ClusterIP))
+	fmt.Fprintf(w, "External IP:\t%s\n", valueOrNone(status.ExternalIP))
+	fmt.Fprintf(w, "Revision:\t%d\n", status.CurrentRevision)
+
+	fmt.Fprintln(w, "Conditions:")
+	if len(status.Conditions) == 0 {
+		fmt.Fprintln(w, "  <none>")
+	} else {
+		fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tAGE\tMESSAGE")
+		for _, cond := range status.Conditions {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", cond.Type, cond.Status, cond.Reason, duration.HumanDuration(now.Sub(cond.LastTransitionTime.Time)), cond.Message)
+		}
+	}
+
+	fmt.Fprintln(w, "Pods:")
+	if status.Pods == nil {
+		fmt.Fprintln(w, "  <none>")
+		return
+	}
+	fmt.Fprintf(w, "  %d total, %d ready, %d up-to-date\n", status.Pods.Total, status.Pods.Ready, status.Pods.UpToDate)
+	if len(status.Pods.UnhealthyPods) > 0 {
+		fmt.Fprintln(w, "  NAME\tNODE\tREADY\tRESTARTS\tIMAGE")
+		for _, pod := range status.Pods.UnhealthyPods {
+			fmt.Fprintf(w, "  %s\t%s\t%t\t%d\t%s\n", pod.Name, valueOrNone(pod.Node), pod.Ready, pod.Restarts, pod.Image)
+		}
+	}
+}
+
+func valueOrNone(v string) string {
+	if v == "" {
+		return "<none>"
+	}
+	return v
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"example.com/nginx-controller/pkg/features"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	colorBlue  = "blue"
+	colorGreen = "green"
+
+	// 旧colorのDeploymentをscale downする時刻(RFC3339)を記録するAnnotation
+	scaleDownAtAnnotation = "nginx.my.domain/scale-down-at"
+
+	// spec.strategy.blueGreen.scaleDownDelaySecondsが未設定の場合の値
+	defaultScaleDownDelaySeconds = int32(30)
+)
+
+// NginxがBlueGreenで更新される設定になっているかを確認する
+// BlueGreenのFeature Gateが無効な場合はRollingUpdateとして扱う
+func isBlueGreen(nginx *nginxv2.Nginx) bool {
+	return nginx.Spec.Strategy.Type == nginxv2.BlueGreenStrategyType && features.Enabled(features.BlueGreen)
+}
+
+// colorに対応するDeploymentの名前
+func (r *NginxReconciler) colorDeploymentName(nginx *nginxv2.Nginx, color string) string {
+	return r.Naming.Deployment(nginx.Name, color)
+}
+
+// blueならgreen、greenならblueを返す
+func otherColor(color string) string {
+	if color == colorBlue {
+		return colorGreen
+	}
+	return colorBlue
+}
+
+// status.activeColorのcolor(未設定の場合はblue)
+func currentActiveColor(nginx *nginxv2.Nginx) string {
+	if color := nginx.Status.ActiveColor; color == colorBlue || color == colorGreen {
+		return color
+	}
+	return colorBlue // 初期値
+}
+
+// spec.strategy.type(またはBlueGreenのFeature Gate)を切り替える前に作成され、まだ残しておくDeploymentの名前
+// 切り替え後にServiceが参照するDeploymentがAvailableになるまでは切り替え前のDeploymentを削除しない
+// (RollingUpdateからBlueGreenへの切り替えで、blueのPodが起動する前にPodがなくなるのを防ぐ)
+func (r *NginxReconciler) previousStrategyDeployments(ctx context.Context, nginx *nginxv2.Nginx) ([]string, error) {
+	current := r.Naming.Deployment(nginx.Name)
+	previous := []string{r.colorDeploymentName(nginx, colorBlue), r.colorDeploymentName(nginx, colorGreen)}
+	if isBlueGreen(nginx) {
+		current = r.colorDeploymentName(nginx, currentActiveColor(nginx))
+		previous = []string{r.Naming.Deployment(nginx.Name)}
+	}
+
+	var deploy appsv1.Deployment
+	err := r.Get(ctx, client.ObjectKey{Namespace: nginx.Namespace, Name: current}, &deploy)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && deploymentAvailable(&deploy, nginxReplicas(nginx)) {
+		return nil, nil
+	}
+
+	var remaining []string
+	for _, name := range previous {
+		var old appsv1.Deployment
+		err := r.Get(ctx, client.ObjectKey{Namespace: nginx.Namespace, Name: name}, &old)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 他のNginxのDeploymentは対象にしない(cleanupOwnerResourcesと同じくOwnerで判定する)
+		if metav1.IsControlledBy(&old, nginx) {
+			remaining = append(remaining, name)
+		}
+	}
+	return remaining, nil
+}
+
+// colorに対応するDeployment/Podに付与するLabel
+func colorLabels(nginx *nginxv2.Nginx, color string) map[string]string {
+	labels := nginxLabels(nginx)
+	labels["color"] = color
+	return labels
+}
+
+// blue/greenの2つのDeploymentを作成/更新し、Serviceがトラフィックを流すべきcolorを返す
+//
+//	①activeのDeploymentがspecと一致していればactiveを更新し、previewはscale downする
+//	②specが変更されていればpreviewのDeploymentに新しいspecを展開する
+//	③previewの全てのPodがAvailableで、promoteのAnnotationが付与されているか自動promoteが有効ならactiveを切り替える
+func (r *NginxReconciler) reconcileBlueGreen(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx, envChecksum string) (string, ctrl.Result, error) {
+	activeColor := currentActiveColor(nginx)
+	previewColor := otherColor(activeColor)
+	replicas := nginxReplicas(nginx)
+
+	var active appsv1.Deployment
+	err := r.Get(ctx, client.ObjectKey{Namespace: nginx.Namespace, Name: r.colorDeploymentName(nginx, activeColor)}, &active)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Unable to fetch active Deployment")
+		return "", ctrl.Result{}, err
+	}
+
+	// ①activeが存在しないかspecと一致している場合はactiveをそのまま更新する
+	if apierrors.IsNotFound(err) || active.Annotations[templateHashAnnotation] == r.podTemplateHash(nginx, envChecksum) {
+		if err := r.CreateOrUpdateDeployment(ctx, log, nginx, r.colorDeploymentName(nginx, activeColor), colorLabels(nginx, activeColor), replicas, envChecksum); err != nil {
+			return "", ctrl.Result{}, err
+		}
+		// 展開中のpreviewがない時に付与されたAnnotationは、次のspecの変更を即座にpromoteしないように削除する
+		if err := r.removePromoteAnnotation(ctx, log, nginx); err != nil {
+			return "", ctrl.Result{}, err
+		}
+		result, err := r.scaleDownPreview(ctx, log, nginx, previewColor)
+		return activeColor, result, err
+	}
+
+	// ②specの変更をpreviewのDeploymentに展開する
+	log.Info("Roll out new spec to " + previewColor + " Deployment for " + nginx.Name)
+	if err := r.CreateOrUpdateDeployment(ctx, log, nginx, r.colorDeploymentName(nginx, previewColor), colorLabels(nginx, previewColor), replicas, envChecksum); err != nil {
+		return "", ctrl.Result{}, err
+	}
+
+	var preview appsv1.Deployment
+	if err := r.Get(ctx, client.ObjectKey{Namespace: nginx.Namespace, Name: r.colorDeploymentName(nginx, previewColor)}, &preview); err != nil {
+		log.Error(err, "Unable to fetch preview Deployment")
+		return "", ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// ③promoteの判定(Annotationによる手動のpromoteもpreviewがAvailableになるまで待つ)
+	// previewのDeploymentのStatusが変わるとReconcileされるのでRequeueはしない
+	promote := nginx.Annotations[nginxv2.PromoteAnnotation] == "true"
+	if !promote && nginx.Spec.Strategy.BlueGreen != nil && nginx.Spec.Strategy.BlueGreen.AutoPromotionEnabled {
+		promote = true
+	}
+	if !promote || !deploymentAvailable(&preview, replicas) {
+		return activeColor, ctrl.Result{}, nil
+	}
+
+	return r.promote(ctx, log, nginx, &active, previewColor)
+}
+
+// previewのcolorをactiveに切り替え、旧activeのDeploymentのscale downを予約する
+func (r *NginxReconciler) promote(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx, oldActive *appsv1.Deployment, newColor string) (string, ctrl.Result, error) {
+	log.Info("Promote " + newColor + " Deployment for " + nginx.Name)
+
+	delay := time.Duration(scaleDownDelaySeconds(nginx)) * time.Second
+
+	// 旧activeのDeploymentにscale downする時刻を記録
+	patch := client.MergeFrom(oldActive.DeepCopy())
+	if oldActive.Annotations == nil {
+		oldActive.Annotations = make(map[string]string)
+	}
+	oldActive.Annotations[scaleDownAtAnnotation] = time.Now().Add(delay).UTC().Format(time.RFC3339)
+	if err := r.Patch(ctx, oldActive, patch); err != nil {
+		log.Error(err, "Unable to schedule scale down of old Deployment")
+		return "", ctrl.Result{}, err
+	}
+
+	// promoteした結果をStatusに記録してからAnnotationを削除する
+	// (Annotationだけ削除されてpromoteが失われるのを防ぐ)
+	nginx.Status.ActiveColor = newColor
+	if err := r.Status().Update(ctx, nginx); err != nil {
+		log.Error(err, "Unable to update Nginx")
+		return "", ctrl.Result{}, err
+	}
+
+	if err := r.removePromoteAnnotation(ctx, log, nginx); err != nil {
+		return "", ctrl.Result{}, err
+	}
+
+	return newColor, ctrl.Result{RequeueAfter: delay}, nil
+}
+
+// NginxからpromoteのAnnotationを削除する
+func (r *NginxReconciler) removePromoteAnnotation(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx) error {
+	if _, ok := nginx.Annotations[nginxv2.PromoteAnnotation]; !ok {
+		return nil
+	}
+	patch := client.MergeFrom(nginx.DeepCopy())
+	delete(nginx.Annotations, nginxv2.PromoteAnnotation)
+	if err := r.Patch(ctx, nginx, patch); err != nil {
+		log.Error(err, "Unable to remove promote annotation from Nginx")
+		return err
+	}
+	return nil
+}
+
+// activeでないcolorのDeploymentをscale downする
+// scale downの時刻が予約されている場合はその時刻までRequeueする
+func (r *NginxReconciler) scaleDownPreview(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx, color string) (ctrl.Result, error) {
+	var deploy appsv1.Deployment
+	if err := r.Get(ctx, client.ObjectKey{Namespace: nginx.Namespace, Name: r.colorDeploymentName(nginx, color)}, &deploy); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if deploy.Spec.Replicas != nil && *deploy.Spec.Replicas == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	// promote直後は旧activeに残っているコネクションのためにscale downを遅延させる
+	if at, ok := deploy.Annotations[scaleDownAtAnnotation]; ok {
+		scaleDownAt, err := time.Parse(time.RFC3339, at)
+		if err == nil && time.Now().Before(scaleDownAt) {
+			return ctrl.Result{RequeueAfter: time.Until(scaleDownAt)}, nil
+		}
+	}
+
+	log.Info("Scale down " + color + " Deployment for " + nginx.Name)
+	patch := client.MergeFrom(deploy.DeepCopy())
+	zero := int32(0)
+	deploy.Spec.Replicas = &zero
+	delete(deploy.Annotations, scaleDownAtAnnotation)
+	if err := r.Patch(ctx, &deploy, patch); err != nil {
+		log.Error(err, "Unable to scale down Deployment")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// Deploymentの全てのPodが最新のTemplateでAvailableになっているかを確認する
+func deploymentAvailable(deploy *appsv1.Deployment, replicas int32) bool {
+	return deploy.Status.ObservedGeneration >= deploy.Generation &&
+		deploy.Status.UpdatedReplicas == replicas &&
+		deploy.Status.AvailableReplicas == replicas
+}
+
+// spec.strategy.blueGreen.scaleDownDelaySecondsを返す
+func scaleDownDelaySeconds(nginx *nginxv2.Nginx) int32 {
+	if nginx.Spec.Strategy.BlueGreen != nil && nginx.Spec.Strategy.BlueGreen.ScaleDownDelaySeconds != nil {
+		return *nginx.Spec.Strategy.BlueGreen.ScaleDownDelaySeconds
+	}
+	return defaultScaleDownDelaySeconds
+}
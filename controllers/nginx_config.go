@@ -0,0 +1,296 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// spec.config.contentを配置するファイル名とディレクトリ
+	configFileName  = "default.conf"
+	configMountPath = "/etc/nginx/conf.d"
+	configVolume    = "config"
+
+	// "nginx -t"で検証済みの設定のハッシュ値を記録するAnnotation
+	configHashAnnotation = "nginx.my.domain/config-hash"
+
+	// "nginx -t"のJobの完了を確認する間隔
+	configCheckInterval = 5 * time.Second
+)
+
+// spec.configが指定されているかを確認する
+func hasConfig(nginx *nginxv2.Nginx) bool {
+	return nginx.Spec.Config != nil && nginx.Spec.Config.Content != ""
+}
+
+// Podにマウントする検証済みの設定を保持するConfigMapの名前
+func (r *NginxReconciler) configMapName(nginx *nginxv2.Nginx) string {
+	return r.Naming.ConfigMap(nginx.Name)
+}
+
+// "nginx -t"で検証する設定を保持するConfigMapの名前
+func (r *NginxReconciler) candidateConfigMapName(nginx *nginxv2.Nginx) string {
+	return r.Naming.ConfigMap(nginx.Name, configValidationHash(nginx))
+}
+
+// "nginx -t"を実行するJobの名前
+func (r *NginxReconciler) configCheckJobName(nginx *nginxv2.Nginx) string {
+	return r.Naming.ConfigCheckJob(nginx.Name, configValidationHash(nginx))
+}
+
+// 検証対象(設定とImage、spec.podTemplateの組み合わせ)のハッシュ値
+// ImageやJobにマウントするVolumeが変わった場合も再度検証する
+func configValidationHash(nginx *nginxv2.Nginx) string {
+	values := []interface{}{nginxImage(nginx), nginx.Spec.Config.Content}
+	if nginx.Spec.PodTemplate != nil {
+		values = append(values, nginx.Spec.PodTemplate)
+	}
+	return computeHash(values)
+}
+
+// spec.configを"nginx -t"で検証し、成功した場合のみPodにマウントするConfigMapを更新する
+// 戻り値のConditionのStatusがTrueの場合のみDeploymentを更新してよい
+func (r *NginxReconciler) reconcileConfig(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx) (metav1.Condition, error) {
+	condition := metav1.Condition{
+		Type:               nginxv2.ConditionConfigValid,
+		ObservedGeneration: nginx.Generation,
+	}
+
+	if !hasConfig(nginx) {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "DefaultConfig"
+		condition.Message = "Using the default configuration of the image"
+		return condition, nil
+	}
+
+	hash := configValidationHash(nginx)
+
+	// 検証済みの設定がConfigMapに反映されていれば何もしない
+	var live corev1.ConfigMap
+	err := r.Get(ctx, client.ObjectKey{Namespace: nginx.Namespace, Name: r.configMapName(nginx)}, &live)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Unable to fetch ConfigMap")
+		return condition, err
+	}
+	if err == nil && live.Annotations[configHashAnnotation] == hash {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Validated"
+		condition.Message = "nginx -t succeeded"
+		return condition, nil
+	}
+
+	var job batchv1.Job
+	err = r.Get(ctx, client.ObjectKey{Namespace: nginx.Namespace, Name: r.configCheckJobName(nginx)}, &job)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Unable to fetch Job")
+		return condition, err
+	}
+
+	switch {
+	case apierrors.IsNotFound(err):
+		// 検証用のConfigMapとJobを作成する
+		if err := r.createConfigCheck(ctx, log, nginx); err != nil {
+			return condition, err
+		}
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = "Validating"
+		condition.Message = "Waiting for nginx -t in Job " + r.configCheckJobName(nginx)
+	case job.Status.Succeeded > 0:
+		// 検証に成功したのでPodにマウントするConfigMapに反映する
+		if err := r.CreateOrUpdateConfigMap(ctx, log, nginx, r.configMapName(nginx), hash); err != nil {
+			return condition, err
+		}
+		if err := r.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "Faild to delete Job")
+			return condition, err
+		}
+		candidate := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: r.candidateConfigMapName(nginx), Namespace: nginx.Namespace}}
+		if err := r.Delete(ctx, candidate); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "Faild to delete ConfigMap")
+			return condition, err
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Validated"
+		condition.Message = "nginx -t succeeded"
+	case job.Status.Failed > 0:
+		output, err := r.configCheckOutput(ctx, &job)
+		if err != nil {
+			return condition, err
+		}
+		log.Info("nginx -t failed for " + nginx.Name + ": " + output)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NginxTestFailed"
+		condition.Message = output
+	default:
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = "Validating"
+		condition.Message = "Waiting for nginx -t in Job " + job.Name
+	}
+
+	return condition, nil
+}
+
+// spec.config.contentを保持するConfigMapを作成/更新
+//
+//	hash: 検証済みの場合はそのハッシュ値(検証用のConfigMapの場合は空)
+func (r *NginxReconciler) CreateOrUpdateConfigMap(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx, name string, hash string) error {
+	log.Info("CreateOrUpdate ConfigMap for " + nginx.Name)
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: nginx.Namespace,
+		},
+	}
+
+	operationResult, err := ctrl.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		if err := r.mutateConfigMap(configMap, nginx, hash); err != nil {
+			log.Error(err, "Unable to set OwnerReference from Nginx to ConfigMap")
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err, "Unable to ensure configmap is correct")
+		return err
+	}
+
+	log.Info("CreateOrUpdate ConfigMap for " + nginx.Name + ": " + string(operationResult))
+
+	return nil
+}
+
+// spec.config.contentを保持するConfigMapの内容を設定する
+// Reconcileとrenderの両方から使用する
+func (r *NginxReconciler) mutateConfigMap(configMap *corev1.ConfigMap, nginx *nginxv2.Nginx, hash string) error {
+	configMap.ObjectMeta.Labels = nginxLabels(nginx)
+	if hash != "" {
+		if configMap.ObjectMeta.Annotations == nil {
+			configMap.ObjectMeta.Annotations = make(map[string]string)
+		}
+		configMap.ObjectMeta.Annotations[configHashAnnotation] = hash
+	}
+	configMap.Data = map[string]string{
+		configFileName: nginx.Spec.Config.Content,
+	}
+
+	// ★ConfigMapにOwnerReferenceを設定
+	return ctrl.SetControllerReference(nginx, configMap, r.Scheme)
+}
+
+// 検証用のConfigMapと、それをマウントして"nginx -t"を実行するJobを作成する
+func (r *NginxReconciler) createConfigCheck(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx) error {
+	if err := r.CreateOrUpdateConfigMap(ctx, log, nginx, r.candidateConfigMapName(nginx), ""); err != nil {
+		return err
+	}
+
+	log.Info("Create Job " + r.configCheckJobName(nginx) + " to validate config for " + nginx.Name)
+
+	backoffLimit := int32(0)
+	// Podに"controller"のLabelを付与するとServiceのselectorに一致してしまうので別のLabelを使用する
+	labels := map[string]string{
+		"app":                        "nginx-config-check",
+		"nginx.my.domain/config-for": nginx.Name,
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.configCheckJobName(nginx),
+			Namespace: nginx.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template:     r.configCheckPodTemplate(nginx, labels),
+		},
+	}
+
+	// ★JobにOwnerReferenceを設定
+	if err := ctrl.SetControllerReference(nginx, job, r.Scheme); err != nil {
+		log.Error(err, "Unable to set OwnerReference from Nginx to Job")
+	}
+
+	if err := r.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+		log.Error(err, "Unable to create Job")
+		return err
+	}
+
+	return nil
+}
+
+// "nginx -t"を実行するJobのPod Template
+// spec.podTemplateのVolumeやenvで参照する証明書などのファイルも検証できるように、Deploymentと同じPod Templateから
+// nginxコンテナのみを残し、検証用のConfigMapをマウントして"nginx -t"を実行する
+func (r *NginxReconciler) configCheckPodTemplate(nginx *nginxv2.Nginx, labels map[string]string) corev1.PodTemplateSpec {
+	var template corev1.PodTemplateSpec
+	r.mutatePodTemplate(&template, nginx, labels, "")
+
+	// sidecarを注入するAnnotationなどでJobが終了しなくならないようにAnnotationは引き継がない
+	template.Annotations = nil
+	template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	template.Spec.ShareProcessNamespace = nil
+
+	container := findContainer(&template.Spec, "nginx").DeepCopy()
+	container.Name = "nginx-test"
+	container.Command = []string{"nginx", "-t"}
+	container.Args = nil
+	container.Ports = nil
+	container.ReadinessProbe = nil
+	container.LivenessProbe = nil
+	container.StartupProbe = nil
+	container.Lifecycle = nil
+	// 失敗した場合は"nginx -t"の出力をterminated.messageから取得する
+	container.TerminationMessagePolicy = corev1.TerminationMessageFallbackToLogsOnError
+	template.Spec.Containers = []corev1.Container{*container}
+
+	// 検証済みのConfigMapの代わりに検証用のConfigMapをマウントする
+	for i := range template.Spec.Volumes {
+		if template.Spec.Volumes[i].Name == configVolume {
+			template.Spec.Volumes[i].ConfigMap.Name = r.candidateConfigMapName(nginx)
+		}
+	}
+
+	return template
+}
+
+// 失敗したJobのPodから"nginx -t"の出力を取得する
+func (r *NginxReconciler) configCheckOutput(ctx context.Context, job *batchv1.Job) (string, error) {
+	var podList corev1.PodList
+	if err := r.List(ctx, &podList, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return "", err
+	}
+
+	for _, pod := range podList.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Terminated != nil && status.State.Terminated.Message != "" {
+				return strings.TrimSpace(status.State.Terminated.Message), nil
+			}
+		}
+	}
+
+	return "nginx -t failed in Job " + job.Name, nil
+}
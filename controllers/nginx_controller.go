@@ -18,26 +18,47 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"strconv"
 
-	nginxv1 "example.com/nginx-controller/api/v1"
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"example.com/nginx-controller/pkg/naming"
+	"example.com/nginx-controller/pkg/sharding"
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 var (
 	OwnerKey = ".metadata.controller"
-	apiGVStr = nginxv1.GroupVersion.String()
+	apiGroup = nginxv2.GroupVersion.Group
+)
+
+const (
+	// Deploymentに展開したPod Templateのハッシュ値を記録するAnnotation
+	templateHashAnnotation = "nginx.my.domain/template-hash"
 )
 
 // NginxReconciler reconciles a Nginx object
@@ -45,10 +66,26 @@ type NginxReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+
+	// 管理するリソースの名前を生成するPolicy
+	Naming naming.Policy
+
+	// 担当するShard(ゼロ値の場合は全てのNginxリソースを担当する)
+	Shard sharding.Shard
+
+	// 同時にReconcileするNginxの数(0の場合は1)
+	MaxConcurrentReconciles int
+
+	// Controllerが動いているNamespace(NetworkPolicyでsidecarへの通信を許可する送信元)
+	ControllerNamespace string
 }
 
 // Nginxリソースに対応したDeploymentを作成/更新
-func (r *NginxReconciler) CreateOrUpdateDeployment(ctx context.Context, log logr.Logger, nginx *nginxv1.Nginx, deploymentName string) error {
+//
+//	labels: Deployment/Podに付与するLabel(blue/greenの場合はcolorを含む)
+//	replicas: Deploymentに設定するReplicas
+//	envChecksum: spec.env/envFromで参照しているConfigMap/Secretのハッシュ値(参照していない場合は空)
+func (r *NginxReconciler) CreateOrUpdateDeployment(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx, deploymentName string, labels map[string]string, replicas int32, envChecksum string) error {
 
 	log.Info("CreateOrUpdate Deployment for " + nginx.Name)
 
@@ -66,68 +103,294 @@ func (r *NginxReconciler) CreateOrUpdateDeployment(ctx context.Context, log logr
 		// コールバック関数funcの中でDeploymentの作成を実施
 		// この関数の中で作成したオブジェクトをもとに差分比較を行うらしい
 		// https://github.com/kubernetes-sigs/controller-runtime/blob/d242fe21e646f034995c4c93e9bba388a0fdaab9/pkg/controller/controllerutil/controllerutil.go#L210-L217
+		if err := r.mutateDeployment(deploy, nginx, labels, replicas, envChecksum); err != nil {
+			log.Error(err, "Unable to set OwnerReference from Nginx to Deployment")
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err, "Unable to ensure deployment is correct")
+		return err
+	}
+
+	log.Info("CreateOrUpdate Deployment for " + nginx.Name + ": " + string(operationResult))
+
+	return nil
+
+}
+
+// Nginxリソースに対応したDeploymentのspecを設定する
+// Reconcileとrenderの両方から使用する
+func (r *NginxReconciler) mutateDeployment(deploy *appsv1.Deployment, nginx *nginxv2.Nginx, labels map[string]string, replicas int32, envChecksum string) error {
+	deploy.ObjectMeta.Labels = labels
+	deploy.Spec.Replicas = &replicas // DeploymentにReplicasを設定
+
+	// Pod Templateのハッシュ値をAnnotationとして記録(blue/greenの差分判定に使用)
+	if deploy.ObjectMeta.Annotations == nil {
+		deploy.ObjectMeta.Annotations = make(map[string]string)
+	}
+	deploy.ObjectMeta.Annotations[templateHashAnnotation] = r.podTemplateHash(nginx, envChecksum)
+	// 新しいTemplateを展開したのでscale downの予約は取り消す
+	delete(deploy.ObjectMeta.Annotations, scaleDownAtAnnotation)
+
+	// RollingUpdateに関する設定(spec.rollout)
+	mutateRollout(deploy, nginx)
+
+	// DeploymentのLabelSelectorにlabelsを設定
+	// https://pkg.go.dev/k8s.io/apimachinery/pkg/apis/meta/v1#LabelSelector
+	if deploy.Spec.Selector == nil {
+		deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	}
+
+	// spec.podTemplate.annotationsから削除されたAnnotationをPod Templateから削除する
+	mutatePodAnnotationKeys(deploy, nginx)
+
+	// Pod TemplateにLabelとContainerを設定
+	// https://pkg.go.dev/k8s.io/api@v0.25.0/core/v1#PodTemplateSpec
+	r.mutatePodTemplate(&deploy.Spec.Template, nginx, labels, envChecksum)
+
+	// ★DeploymentにOwnerReferenceを設定
+	// https://pkg.go.dev/sigs.k8s.io/controller-runtime/pkg/controller/controllerutil#SetControllerReference
+	return ctrl.SetControllerReference(nginx, deploy, r.Scheme)
+}
+
+// spec.rolloutの値をDeploymentに設定する
+// 未指定の項目にはDeploymentのデフォルト値を設定し、削除された項目が残らないようにする
+func mutateRollout(deploy *appsv1.Deployment, nginx *nginxv2.Nginx) {
+	rollout := nginx.Spec.Rollout
+	if rollout == nil {
+		rollout = &nginxv2.RolloutSpec{}
+	}
+
+	maxSurge := intstr.FromString("25%")
+	if rollout.MaxSurge != nil {
+		maxSurge = *rollout.MaxSurge
+	}
+	maxUnavailable := intstr.FromString("25%")
+	if rollout.MaxUnavailable != nil {
+		maxUnavailable = *rollout.MaxUnavailable
+	}
+	deploy.Spec.Strategy = appsv1.DeploymentStrategy{
+		Type: appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{
+			MaxSurge:       &maxSurge,
+			MaxUnavailable: &maxUnavailable,
+		},
+	}
 
-		// LabelをMapで定義
-		labels := map[string]string{
-			"app":        "nginx",
-			"controller": nginx.Name,
+	deploy.Spec.MinReadySeconds = 0
+	if rollout.MinReadySeconds != nil {
+		deploy.Spec.MinReadySeconds = *rollout.MinReadySeconds
+	}
+
+	progressDeadlineSeconds := int32(600)
+	if rollout.ProgressDeadlineSeconds != nil {
+		progressDeadlineSeconds = *rollout.ProgressDeadlineSeconds
+	}
+	deploy.Spec.ProgressDeadlineSeconds = &progressDeadlineSeconds
+
+	revisionHistoryLimit := int32(10)
+	if rollout.RevisionHistoryLimit != nil {
+		revisionHistoryLimit = *rollout.RevisionHistoryLimit
+	}
+	deploy.Spec.RevisionHistoryLimit = &revisionHistoryLimit
+}
+
+// Nginxリソースが管理するPodのPod Templateを設定する
+// 既存のTemplateに対して必要なフィールドのみを上書きする(API Serverが設定したデフォルト値は残す)
+func (r *NginxReconciler) mutatePodTemplate(template *corev1.PodTemplateSpec, nginx *nginxv2.Nginx, labels map[string]string, envChecksum string) {
+	// spec.podTemplate.labelsから削除されたLabelも反映するため作り直す
+	template.Labels = podLabels(nginx, labels)
+
+	// Containerをarrayで定義
+	// https://pkg.go.dev/k8s.io/api@v0.25.0/core/v1#Container
+	container := findContainer(&template.Spec, "nginx")
+	if container == nil {
+		template.Spec.Containers = append(template.Spec.Containers, corev1.Container{Name: "nginx"})
+		container = &template.Spec.Containers[len(template.Spec.Containers)-1]
+	}
+	container.Image = nginxImage(nginx)
+	container.Ports = []corev1.ContainerPort{{
+		Name:          nginxv2.PortName,
+		ContainerPort: nginxPort(nginx),
+		Protocol:      corev1.ProtocolTCP,
+	}}
+	container.ReadinessProbe = nginx.Spec.ReadinessProbe.DeepCopy()
+	container.LivenessProbe = nginx.Spec.LivenessProbe.DeepCopy()
+	container.Resources = corev1.ResourceRequirements{}
+	if nginx.Spec.Resources != nil {
+		container.Resources = *nginx.Spec.Resources.DeepCopy()
+	}
+
+	// spec.env/envFrom(参照しているConfigMap/Secretの変更でPodを再作成する)
+	mutateEnv(template, container, nginx, envChecksum)
+
+	// spec.configがある場合は検証済みのConfigMapをマウントする
+	// Restartの場合はConfigMapの変更でPodが再作成されるようにハッシュ値をAnnotationに設定する
+	// HotReloadの場合はsidecarがnginxに再読み込みさせるのでAnnotationは設定しない
+	if hasConfig(nginx) && !isHotReload(nginx) {
+		if template.Annotations == nil {
+			template.Annotations = make(map[string]string)
 		}
+		template.Annotations[configHashAnnotation] = configContentHash(nginx)
+	} else {
+		delete(template.Annotations, configHashAnnotation)
+	}
 
-		deploy.ObjectMeta.Labels = labels
-		replicas := int32(1) // 初期値
-		if nginx.Spec.Replicas != nil {
-			replicas = *nginx.Spec.Replicas // Nginx ObjectのSpecからReplicasを取得
+	// kubectl nginx reloadで付与されたAnnotationをコピーし、値の変更でPodを再作成する
+	if restartedAt, ok := nginx.Annotations[nginxv2.RestartedAtAnnotation]; ok {
+		if template.Annotations == nil {
+			template.Annotations = make(map[string]string)
 		}
-		deploy.Spec.Replicas = &replicas // DeploymentにReplicasを設定
+		template.Annotations[nginxv2.RestartedAtAnnotation] = restartedAt
+	} else {
+		delete(template.Annotations, nginxv2.RestartedAtAnnotation)
+	}
+
+	if hasConfig(nginx) {
+		defaultMode := corev1.ConfigMapVolumeSourceDefaultMode
+		setVolume(&template.Spec, corev1.Volume{
+			Name: configVolume,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: r.configMapName(nginx)},
+					DefaultMode:          &defaultMode,
+				},
+			},
+		})
+		setVolumeMount(container, corev1.VolumeMount{Name: configVolume, MountPath: configMountPath})
+	} else {
+		removeVolume(&template.Spec, configVolume)
+		removeVolumeMount(container, configVolume)
+	}
+
+	// HotReloadの場合は設定の変更を反映するsidecarを追加する
+	mutateReloader(&template.Spec, container, nginx)
+
+	// spec.securityContext(sidecarを含む全てのコンテナに設定する)
+	mutateSecurityContext(&template.Spec, nginx)
+
+	// spec.podTemplate(sidecar、init container、Volume、Annotation)
+	mutatePodTemplateOverrides(template, container, nginx)
 
-		// DeploymentのLabelSelectorにlabelsを設定
-		// https://pkg.go.dev/k8s.io/apimachinery/pkg/apis/meta/v1#LabelSelector
-		if deploy.Spec.Selector == nil {
-			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	// spec.serviceAccount
+	r.mutateServiceAccountName(&template.Spec, nginx)
+}
+
+// nginxコンテナのImage(未指定の場合はControllerConfigのdefaultImage、hardenedの場合はHardenedImage)
+func nginxImage(nginx *nginxv2.Nginx) string {
+	if nginx.Spec.Image == "" {
+		if nginx.Spec.IsHardened() {
+			return nginxv2.HardenedImage
 		}
+		return nginxv2.BuiltinImage()
+	}
+	return nginx.Spec.Image
+}
 
-		// Pod Templateにlabelsを設定
-		// https://pkg.go.dev/k8s.io/api@v0.25.0/core/v1#PodTemplateSpec
-		if deploy.Spec.Template.Labels == nil {
-			deploy.Spec.Template.Labels = labels
+// nginxコンテナがListenするPort(未指定の場合は80、hardenedの場合は8080)
+func nginxPort(nginx *nginxv2.Nginx) int32 {
+	if nginx.Spec.Port == nil {
+		if nginx.Spec.IsHardened() {
+			return nginxv2.HardenedPort
 		}
+		return nginxv2.DefaultPort
+	}
+	return *nginx.Spec.Port
+}
 
-		// Containerをarrayで定義
-		// https://pkg.go.dev/k8s.io/api@v0.25.0/core/v1#Container
-		containers := []corev1.Container{
-			{
-				Name:  "nginx",
-				Image: "nginx:latest",
-			},
+// 名前が一致するContainerへのポインタを返す(存在しない場合はnil)
+func findContainer(spec *corev1.PodSpec, name string) *corev1.Container {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == name {
+			return &spec.Containers[i]
 		}
+	}
+	return nil
+}
 
-		// Pod TemplateにContainerを設定
-		if deploy.Spec.Template.Spec.Containers == nil {
-			deploy.Spec.Template.Spec.Containers = containers
+// 名前が一致するVolumeを置き換える(存在しない場合は追加する)
+func setVolume(spec *corev1.PodSpec, volume corev1.Volume) {
+	for i := range spec.Volumes {
+		if spec.Volumes[i].Name == volume.Name {
+			spec.Volumes[i] = volume
+			return
 		}
+	}
+	spec.Volumes = append(spec.Volumes, volume)
+}
 
-		// ★DeploymentにOwnerReferenceを設定
-		// https://pkg.go.dev/sigs.k8s.io/controller-runtime/pkg/controller/controllerutil#SetControllerReference
-		if err := ctrl.SetControllerReference(nginx, deploy, r.Scheme); err != nil {
-			log.Error(err, "Unable to set OwnerReference from Nginx to Deployment")
+// 名前が一致するVolumeを削除する
+func removeVolume(spec *corev1.PodSpec, name string) {
+	for i := range spec.Volumes {
+		if spec.Volumes[i].Name == name {
+			spec.Volumes = append(spec.Volumes[:i], spec.Volumes[i+1:]...)
+			return
 		}
+	}
+}
 
-		return nil
+// 名前が一致するVolumeMountを置き換える(存在しない場合は追加する)
+func setVolumeMount(container *corev1.Container, mount corev1.VolumeMount) {
+	for i := range container.VolumeMounts {
+		if container.VolumeMounts[i].Name == mount.Name {
+			container.VolumeMounts[i] = mount
+			return
+		}
+	}
+	container.VolumeMounts = append(container.VolumeMounts, mount)
+}
 
-	})
-	if err != nil {
-		log.Error(err, "Unable to ensure deployment is correct")
-		return err
+// 名前が一致するVolumeMountを削除する
+func removeVolumeMount(container *corev1.Container, name string) {
+	for i := range container.VolumeMounts {
+		if container.VolumeMounts[i].Name == name {
+			container.VolumeMounts = append(container.VolumeMounts[:i], container.VolumeMounts[i+1:]...)
+			return
+		}
 	}
+}
 
-	log.Info("CreateOrUpdate Deployment for " + nginx.Name + ": " + string(operationResult))
+// Nginxリソースから生成されるPod Templateのハッシュ値を返す
+// colorのLabelは含めないため、blue/greenどちらのDeploymentでも同じ値になる
+// 参照しているConfigMap/Secretの内容(envChecksum)が変わった場合も異なる値になる
+func (r *NginxReconciler) podTemplateHash(nginx *nginxv2.Nginx, envChecksum string) string {
+	template := corev1.PodTemplateSpec{}
+	r.mutatePodTemplate(&template, nginx, nginxLabels(nginx), envChecksum)
 
-	return nil
+	return computeHash(template)
+}
 
+// オブジェクトをJSONにした値からハッシュ値を計算する
+func computeHash(obj interface{}) string {
+	hasher := fnv.New32a()
+	b, _ := json.Marshal(obj)
+	hasher.Write(b)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// Nginxリソースが管理するリソースに共通で付与するLabel
+func nginxLabels(nginx *nginxv2.Nginx) map[string]string {
+	return map[string]string{
+		"app":        "nginx",
+		"controller": nginx.Name,
+	}
+}
+
+// Nginxリソースが管理するDeploymentのReplicas
+func nginxReplicas(nginx *nginxv2.Nginx) int32 {
+	replicas := int32(1) // 初期値
+	if nginx.Spec.Replicas != nil {
+		replicas = *nginx.Spec.Replicas // Nginx ObjectのSpecからReplicasを取得
+	}
+	return replicas
 }
 
 // Nginxリソースに対応したServiceを作成/更新
-func (r *NginxReconciler) CreateOrUpdateService(ctx context.Context, log logr.Logger, nginx *nginxv1.Nginx, serviceName string) error {
+//
+//	selector: Serviceがトラフィックを流すPodのLabel(blue/greenの場合はcolorを含む)
+//	serviceType: ServiceのType
+func (r *NginxReconciler) CreateOrUpdateService(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx, serviceName string, selector map[string]string, spec nginxv2.ServiceSpec) error {
 	log.Info("CreateOrUpdate Service for " + nginx.Name)
 
 	var operationResult controllerutil.OperationResult
@@ -139,37 +402,10 @@ func (r *NginxReconciler) CreateOrUpdateService(ctx context.Context, log logr.Lo
 		},
 	}
 
-	service.ObjectMeta.Labels = map[string]string{
-		"app":        "nginx",
-		"controller": nginx.Name,
-	}
-
 	operationResult, err := ctrl.CreateOrUpdate(ctx, r.Client, service, func() error {
-
-		// spec.selectorにlabelsを設定
-		if service.Spec.Selector == nil {
-			service.Spec.Selector = map[string]string{
-				"controller": nginx.Name,
-			}
-		}
-
-		// spec.portを設定
-		if service.Spec.Ports == nil {
-			service.Spec.Ports = []corev1.ServicePort{{
-				Protocol:   corev1.ProtocolTCP,
-				Port:       80,
-				TargetPort: intstr.IntOrString{IntVal: 80},
-			}}
-		}
-
-		service.Spec.Type = nginx.Spec.ServiceType
-
-		// ★ServiceにOwnerReferenceを設定
-		// https://pkg.go.dev/sigs.k8s.io/controller-runtime/pkg/controller/controllerutil#SetControllerReference
-		if err := ctrl.SetControllerReference(nginx, service, r.Scheme); err != nil {
+		if err := r.mutateService(service, nginx, selector, spec); err != nil {
 			log.Error(err, "Unable to set OwnerReference from Nginx to Service")
 		}
-
 		return nil
 	})
 
@@ -183,8 +419,70 @@ func (r *NginxReconciler) CreateOrUpdateService(ctx context.Context, log logr.Lo
 	return nil
 }
 
-// OwnerReferenceに設定されたNginxリソースの名前に対応しないDeploymentまたはServiceを削除する
-func (r *NginxReconciler) cleanupOwnerResources(ctx context.Context, log logr.Logger, nginx *nginxv1.Nginx) error {
+// Nginxリソースに対応したServiceのspecを設定する
+// Reconcileとrenderの両方から使用する
+func (r *NginxReconciler) mutateService(service *corev1.Service, nginx *nginxv2.Nginx, selector map[string]string, spec nginxv2.ServiceSpec) error {
+	service.ObjectMeta.Labels = nginxLabels(nginx)
+
+	// spec.selectorにlabelsを設定
+	// blue/greenの場合はpromoteでcolorが切り替わるので毎回上書きする
+	service.Spec.Selector = selector
+
+	// spec.portを設定
+	if service.Spec.Ports == nil {
+		service.Spec.Ports = []corev1.ServicePort{{
+			Protocol: corev1.ProtocolTCP,
+			Port:     80,
+		}}
+	}
+	service.Spec.Ports[0].TargetPort = intstr.FromInt(int(nginxPort(nginx)))
+
+	service.Spec.Type = spec.Type
+	mutateServiceSpec(&service.Spec, spec)
+
+	// ★ServiceにOwnerReferenceを設定
+	// https://pkg.go.dev/sigs.k8s.io/controller-runtime/pkg/controller/controllerutil#SetControllerReference
+	return ctrl.SetControllerReference(nginx, service, r.Scheme)
+}
+
+// spec.serviceのTypeに依存する項目をServiceに設定する
+// 未指定の場合はServiceのデフォルト値を設定し、削除された項目が残らないようにする
+func mutateServiceSpec(service *corev1.ServiceSpec, spec nginxv2.ServiceSpec) {
+	external := spec.Type == corev1.ServiceTypeNodePort || spec.Type == corev1.ServiceTypeLoadBalancer
+
+	service.ExternalTrafficPolicy = ""
+	if external {
+		service.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeCluster
+		if spec.ExternalTrafficPolicy != "" {
+			service.ExternalTrafficPolicy = spec.ExternalTrafficPolicy
+		}
+	}
+
+	service.LoadBalancerSourceRanges = nil
+	if spec.Type == corev1.ServiceTypeLoadBalancer {
+		service.LoadBalancerSourceRanges = spec.LoadBalancerSourceRanges
+		// loadBalancerClassは作成後に変更できないので未設定の場合のみ設定する
+		if service.LoadBalancerClass == nil {
+			service.LoadBalancerClass = spec.LoadBalancerClass
+		}
+	}
+}
+
+// Nginxリソースが現在管理しているリソースの名前
+type managedResources struct {
+	deployments []string
+	services    []string
+	configMaps  []string
+	jobs        []string
+	pdbs        []string
+	policies    []string
+	accounts    []string
+}
+
+// OwnerReferenceに設定されたNginxリソースの名前に対応しないDeployment/Service/ConfigMap/Job/PodDisruptionBudget/NetworkPolicy/ServiceAccountを削除する
+//
+//	managed: Nginxリソースが現在管理しているリソースの名前
+func (r *NginxReconciler) cleanupOwnerResources(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx, managed managedResources) error {
 	// log.Info("Finding existing Deployments for Nginx resource")
 
 	/* 以下の条件でDeploymentのListを取得
@@ -197,8 +495,8 @@ func (r *NginxReconciler) cleanupOwnerResources(ctx context.Context, log logr.Lo
 	}
 
 	for _, deployment := range deploymentList.Items {
-		// 取得したDeployment名とNginxで作成されたDeployment名(deploymentName)を比較
-		if deployment.Name == nginx.Status.DeploymentName { // trueならDeploymentがNginxに管理されていることになるので削除しない
+		// 取得したDeployment名とNginxで作成されたDeployment名(managed.deployments)を比較
+		if containsString(managed.deployments, deployment.Name) { // trueならDeploymentがNginxに管理されていることになるので削除しない
 			// 比較した結果が一致したら何もしない
 			continue // 処理をスキップ
 		}
@@ -217,7 +515,7 @@ func (r *NginxReconciler) cleanupOwnerResources(ctx context.Context, log logr.Lo
 		return err
 	}
 	for _, service := range serviceList.Items {
-		if service.Name == nginx.Status.ServiceName {
+		if containsString(managed.services, service.Name) {
 			continue
 		}
 
@@ -228,9 +526,100 @@ func (r *NginxReconciler) cleanupOwnerResources(ctx context.Context, log logr.Lo
 		log.Info("Delete old Service resource: " + service.Name)
 	}
 
+	var configMapList corev1.ConfigMapList
+	if err := r.List(ctx, &configMapList, client.InNamespace(nginx.Namespace), client.MatchingFields(map[string]string{OwnerKey: nginx.Name})); err != nil {
+		return err
+	}
+	for _, configMap := range configMapList.Items {
+		if containsString(managed.configMaps, configMap.Name) {
+			continue
+		}
+
+		if err := r.Delete(ctx, &configMap); err != nil {
+			log.Error(err, "Faild to delete old ConfigMap")
+			return err
+		}
+		log.Info("Delete old ConfigMap resource: " + configMap.Name)
+	}
+
+	var jobList batchv1.JobList
+	if err := r.List(ctx, &jobList, client.InNamespace(nginx.Namespace), client.MatchingFields(map[string]string{OwnerKey: nginx.Name})); err != nil {
+		return err
+	}
+	for _, job := range jobList.Items {
+		if containsString(managed.jobs, job.Name) {
+			continue
+		}
+
+		// Jobが作成したPodも合わせて削除する
+		if err := r.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+			log.Error(err, "Faild to delete old Job")
+			return err
+		}
+		log.Info("Delete old Job resource: " + job.Name)
+	}
+
+	var pdbList policyv1.PodDisruptionBudgetList
+	if err := r.List(ctx, &pdbList, client.InNamespace(nginx.Namespace), client.MatchingFields(map[string]string{OwnerKey: nginx.Name})); err != nil {
+		return err
+	}
+	for _, pdb := range pdbList.Items {
+		if containsString(managed.pdbs, pdb.Name) {
+			continue
+		}
+
+		if err := r.Delete(ctx, &pdb); err != nil {
+			log.Error(err, "Faild to delete old PodDisruptionBudget")
+			return err
+		}
+		log.Info("Delete old PodDisruptionBudget resource: " + pdb.Name)
+	}
+
+	var policyList networkingv1.NetworkPolicyList
+	if err := r.List(ctx, &policyList, client.InNamespace(nginx.Namespace), client.MatchingFields(map[string]string{OwnerKey: nginx.Name})); err != nil {
+		return err
+	}
+	for _, policy := range policyList.Items {
+		if containsString(managed.policies, policy.Name) {
+			continue
+		}
+
+		if err := r.Delete(ctx, &policy); err != nil {
+			log.Error(err, "Faild to delete old NetworkPolicy")
+			return err
+		}
+		log.Info("Delete old NetworkPolicy resource: " + policy.Name)
+	}
+
+	var accountList corev1.ServiceAccountList
+	if err := r.List(ctx, &accountList, client.InNamespace(nginx.Namespace), client.MatchingFields(map[string]string{OwnerKey: nginx.Name})); err != nil {
+		return err
+	}
+	for _, account := range accountList.Items {
+		if containsString(managed.accounts, account.Name) {
+			continue
+		}
+
+		if err := r.Delete(ctx, &account); err != nil {
+			log.Error(err, "Faild to delete old ServiceAccount")
+			return err
+		}
+		log.Info("Delete old ServiceAccount resource: " + account.Name)
+	}
+
 	return nil
 }
 
+// sliceに文字列が含まれているかを確認する
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 //+kubebuilder:rbac:groups=nginx.my.domain,resources=nginxes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=nginx.my.domain,resources=nginxes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=nginx.my.domain,resources=nginxes/finalizers,verbs=update
@@ -238,6 +627,17 @@ func (r *NginxReconciler) cleanupOwnerResources(ctx context.Context, log logr.Lo
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apps,resources=deployments/finalizers,verbs=update
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=nginx.my.domain,resources=nginxclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
 //+kubebuilder:rbac:groups=apps,resources=services/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apps,resources=services/finalizers,verbs=update
 
@@ -250,7 +650,13 @@ func (r *NginxReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 
 	log := log.FromContext(ctx) // contextに含まれるvalueを付与してログを出力するlogger
 
-	var nginx nginxv1.Nginx
+	// 管理リソースやPodのイベントはOwnerのNginxに変換されるため、For()のPredicateを通らない
+	// 他のShardが担当するNginxはここで除外する
+	if !r.Shard.Contains(req.Namespace, req.Name) {
+		return ctrl.Result{}, nil
+	}
+
+	var nginx nginxv2.Nginx
 	var deployment appsv1.Deployment
 	var service corev1.Service
 	var err error
@@ -261,24 +667,128 @@ func (r *NginxReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	deploymentName := "deploy-" + nginx.Name // Nginxにより管理されるDeploymentの名前
-	serviceName := "service-" + nginx.Name   // Nginxにより管理されるServiceの名前
+	// ①-2 rollbackが指定されている場合はspecを更新して終了する(更新後に再度Reconcileされる)
+	if revision, ok := rollbackRevision(&nginx); ok {
+		return ctrl.Result{}, r.rollback(ctx, log, &nginx, revision)
+	}
+
+	deploymentName := r.Naming.Deployment(nginx.Name) // Nginxにより管理されるDeploymentの名前
+	serviceName := r.Naming.Service(nginx.Name)       // Nginxにより管理されるServiceの名前
+	previewServiceName := ""                          // BlueGreenの場合にpreviewを公開するServiceの名前
+	activeColor := ""                                 // BlueGreenの場合にServiceがトラフィックを流すcolor
+	selector := map[string]string{"controller": nginx.Name}
+
+	managed := managedResources{
+		deployments: []string{deploymentName},
+		services:    []string{serviceName},
+	}
+	if isBlueGreen(&nginx) {
+		previewServiceName = r.Naming.Service(nginx.Name, "preview")
+		managed.deployments = []string{r.colorDeploymentName(&nginx, colorBlue), r.colorDeploymentName(&nginx, colorGreen)}
+		managed.services = append(managed.services, previewServiceName)
+	}
+	if hasConfig(&nginx) {
+		managed.configMaps = []string{r.configMapName(&nginx), r.candidateConfigMapName(&nginx)}
+		managed.jobs = []string{r.configCheckJobName(&nginx)}
+	}
+	if hasDisruptionBudget(&nginx) {
+		managed.pdbs = []string{r.pdbName(&nginx)}
+	}
+	if hasNetworkPolicy(&nginx) {
+		managed.policies = []string{r.networkPolicyName(&nginx)}
+	}
+	if hasServiceAccount(&nginx) {
+		managed.accounts = []string{r.serviceAccountName(&nginx)}
+	}
+
+	// ②-0 strategyを切り替えた場合は新しいDeploymentがAvailableになるまで切り替え前のDeploymentを残す
+	previousDeployments, err := r.previousStrategyDeployments(ctx, &nginx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	managed.deployments = append(managed.deployments, previousDeployments...)
+
+	// ②-1 Nginxが過去に管理していたリソースを削除する
+	if err = r.cleanupOwnerResources(ctx, log, &nginx, managed); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// ②-2 PodのServiceAccountを作成/更新する(nginx -tのJobとPodより先に作成する)
+	if hasServiceAccount(&nginx) {
+		if err = r.CreateOrUpdateServiceAccount(ctx, log, &nginx); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
 
-	// ②-1 Nginxが過去に管理していたDeploymentまたはServiceを削除する
-	if err = r.cleanupOwnerResources(ctx, log, &nginx); err != nil {
+	// ②-3 spec.configを"nginx -t"で検証し、成功した場合のみConfigMapに反映する
+	// 検証中または失敗した場合はDeploymentのみ更新せず、他のリソースとStatusは更新する
+	configValid, err := r.reconcileConfig(ctx, log, &nginx)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	configReady := configValid.Status == metav1.ConditionTrue
 
-	// ③-1 Nginxが管理するDeploymentを作成/更新する
-	if err = r.CreateOrUpdateDeployment(ctx, log, &nginx, deploymentName); err != nil {
+	// ②-4 spec.env/envFromで参照しているConfigMap/Secretのハッシュ値を計算する
+	envChecksum, envSources, err := r.reconcileEnvChecksum(ctx, log, &nginx)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	// ③-2 Nginxが管理するServiceを作成/更新
-	if err = r.CreateOrUpdateService(ctx, log, &nginx, serviceName); err != nil {
+	var result ctrl.Result
+
+	// 検証中はJobの完了を待つ(Jobの変更でもReconcileされるが、取りこぼした場合に備えて定期的に確認する)
+	if configValid.Status == metav1.ConditionUnknown {
+		result.RequeueAfter = configCheckInterval
+	}
+
+	if isBlueGreen(&nginx) {
+		// ③-1 blue/greenのDeploymentを作成/更新しpromoteを判定する(設定の検証が完了するまではactiveのcolorのまま)
+		activeColor = currentActiveColor(&nginx)
+		if configReady {
+			var blueGreenResult ctrl.Result
+			activeColor, blueGreenResult, err = r.reconcileBlueGreen(ctx, log, &nginx, envChecksum)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			result = blueGreenResult
+		}
+		deploymentName = r.colorDeploymentName(&nginx, activeColor)
+		// RollingUpdateから切り替えた直後は切り替え前のPodにもトラフィックを流す
+		if len(previousDeployments) == 0 {
+			selector["color"] = activeColor
+		}
+
+		// ③-2 previewのcolorを公開するServiceを作成/更新
+		previewSelector := map[string]string{"controller": nginx.Name, "color": otherColor(activeColor)}
+		if err = r.CreateOrUpdateService(ctx, log, &nginx, previewServiceName, previewSelector, nginxv2.ServiceSpec{Type: corev1.ServiceTypeClusterIP}); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else if configReady {
+		// ③-1 Nginxが管理するDeploymentを作成/更新する
+		if err = r.CreateOrUpdateDeployment(ctx, log, &nginx, deploymentName, nginxLabels(&nginx), nginxReplicas(&nginx), envChecksum); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// ③-3 Nginxが管理するServiceを作成/更新
+	if err = r.CreateOrUpdateService(ctx, log, &nginx, serviceName, selector, nginx.Spec.Service); err != nil {
 		return ctrl.Result{}, err
 	}
 
+	// ③-4 spec.disruptionBudgetが指定されている場合はPodDisruptionBudgetを作成/更新
+	if hasDisruptionBudget(&nginx) {
+		if err = r.CreateOrUpdatePodDisruptionBudget(ctx, log, &nginx); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// ③-5 spec.networkPolicyが指定されている場合はNetworkPolicyを作成/更新
+	if hasNetworkPolicy(&nginx) {
+		if err = r.CreateOrUpdateNetworkPolicy(ctx, log, &nginx); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	// ④Nginx ObjectのStatusを更新する
 	// controller-runtimeのclientで定義されているObjectKey型でDeploymentのNamespacedNameを設定
 	// https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/client#ObjectKey
@@ -293,21 +803,28 @@ func (r *NginxReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 	}
 
 	// NamespacedNameを用いてDeploymentをcacheから取得
+	// 設定の検証が完了していない場合はDeploymentがまだ作成されていないことがある
+	deploymentFound := true
 	if err = r.Get(ctx, deploymentNamespacedName, &deployment); err != nil {
-		log.Error(err, "Unable to fetch Deployment from cache")
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+		if !apierrors.IsNotFound(err) || configReady {
+			log.Error(err, "Unable to fetch Deployment from cache")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		deploymentFound = false
 	}
 
-	// Nginx StatusのAvailableReplicasに関する差分比較&更新
-	if nginx.Status.AvailableReplicas != deployment.Status.AvailableReplicas {
-		nginx.Status.AvailableReplicas = deployment.Status.AvailableReplicas
-		statusUpdateFlag = true
-	}
+	if deploymentFound {
+		// Nginx StatusのAvailableReplicasに関する差分比較&更新
+		if nginx.Status.AvailableReplicas != deployment.Status.AvailableReplicas {
+			nginx.Status.AvailableReplicas = deployment.Status.AvailableReplicas
+			statusUpdateFlag = true
+		}
 
-	// Nginx StatusのDeploymentNameに関する差分比較&更新
-	if nginx.Status.DeploymentName != deployment.Name {
-		nginx.Status.DeploymentName = deployment.Name
-		statusUpdateFlag = true
+		// Nginx StatusのDeploymentNameに関する差分比較&更新
+		if nginx.Status.DeploymentName != deployment.Name {
+			nginx.Status.DeploymentName = deployment.Name
+			statusUpdateFlag = true
+		}
 	}
 
 	serviceNamespacedName := client.ObjectKey{
@@ -345,9 +862,84 @@ func (r *NginxReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		statusUpdateFlag = true
 	}
 
+	// Nginx StatusのDegraded Conditionに関する差分比較&更新
+	degraded, err := r.degradedCondition(ctx, &nginx, managed.deployments)
+	if err != nil {
+		log.Error(err, "Unable to fetch Deployment from cache")
+		return ctrl.Result{}, err
+	}
+	if setCondition(&nginx.Status.Conditions, degraded) {
+		statusUpdateFlag = true
+	}
+
+	// Nginx StatusのConfigValid Conditionに関する差分比較&更新
+	if setCondition(&nginx.Status.Conditions, configValid) {
+		statusUpdateFlag = true
+	}
+
+	// Nginx StatusのEnvSourcesFound Conditionに関する差分比較&更新
+	if setCondition(&nginx.Status.Conditions, envSources) {
+		statusUpdateFlag = true
+	}
+
+	// 現在のspecでPodが全てAvailableになっていればControllerRevisionとして保存する
+	if configReady && deploymentFound && degraded.Status == metav1.ConditionFalse &&
+		deployment.Annotations[templateHashAnnotation] == r.podTemplateHash(&nginx, envChecksum) &&
+		deploymentAvailable(&deployment, nginxReplicas(&nginx)) {
+		if err = r.recordRevision(ctx, log, &nginx); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Nginx StatusのRevisions/CurrentRevisionに関する差分比較&更新
+	revisions, currentRevision, err := r.specRevisions(ctx, &nginx)
+	if err != nil {
+		log.Error(err, "Unable to list ControllerRevisions")
+		return ctrl.Result{}, err
+	}
+	if !equality.Semantic.DeepEqual(nginx.Status.Revisions, revisions) {
+		nginx.Status.Revisions = revisions
+		statusUpdateFlag = true
+	}
+	if nginx.Status.CurrentRevision != currentRevision {
+		nginx.Status.CurrentRevision = currentRevision
+		statusUpdateFlag = true
+	}
+
+	// Nginx StatusのConfigHashに関する差分比較&更新
+	if nginx.Status.ConfigHash != configContentHash(&nginx) {
+		nginx.Status.ConfigHash = configContentHash(&nginx)
+		statusUpdateFlag = true
+	}
+
+	// Nginx StatusのPodsに関する差分比較&更新
+	pods, err := r.podsStatus(ctx, log, &nginx, activeColor)
+	if err != nil {
+		log.Error(err, "Unable to list Pods")
+		return ctrl.Result{}, err
+	}
+	if !equality.Semantic.DeepEqual(nginx.Status.Pods, pods) {
+		nginx.Status.Pods = pods
+		statusUpdateFlag = true
+	}
+	// HotReloadの場合、Podの設定の反映はWatchで検知できないので定期的に確認する
+	if isHotReload(&nginx) && (result.RequeueAfter == 0 || result.RequeueAfter > reloadStatusInterval) {
+		result.RequeueAfter = reloadStatusInterval
+	}
+
+	// Nginx StatusのActiveColor/PreviewServiceNameに関する差分比較&更新
+	if nginx.Status.ActiveColor != activeColor {
+		nginx.Status.ActiveColor = activeColor
+		statusUpdateFlag = true
+	}
+	if nginx.Status.PreviewServiceName != previewServiceName {
+		nginx.Status.PreviewServiceName = previewServiceName
+		statusUpdateFlag = true
+	}
+
 	// Nginx Objectの更新(差分ありの場合)
 	if statusUpdateFlag {
-		log.Info("Update Nginx Status.(nginx.Status.DeploymentName: " + nginx.Status.DeploymentName + ", nginx.Status.AvailableReplicas: " + strconv.Itoa(int(nginx.Status.AvailableReplicas)))
+		log.Info("Update Nginx Status.(nginx.Status.DeploymentName: " + nginx.Status.DeploymentName + ", nginx.Status.AvailableReplicas: " + strconv.Itoa(int(nginx.Status.AvailableReplicas)) + ", nginx.Status.ActiveColor: " + nginx.Status.ActiveColor)
 		fmt.Println("  nginx.Status.DeploymentName: " + nginx.Status.DeploymentName)
 		fmt.Println("  nginx.Status.AvailableReplicas: " + strconv.Itoa(int(nginx.Status.AvailableReplicas)))
 		fmt.Println("  nginx.Status.ServiceName: " + nginx.Status.ServiceName)
@@ -360,7 +952,54 @@ func (r *NginxReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		}
 	}
 
-	return ctrl.Result{}, nil
+	return result, nil
+}
+
+// 管理するDeploymentのConditionからNginxのDegraded Conditionを生成する
+// いずれかのDeploymentがProgressDeadlineExceededになっていればDegradedとする
+func (r *NginxReconciler) degradedCondition(ctx context.Context, nginx *nginxv2.Nginx, deploymentNames []string) (metav1.Condition, error) {
+	condition := metav1.Condition{
+		Type:               nginxv2.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             "RolloutProgressing",
+		Message:            "All Deployments are progressing",
+		ObservedGeneration: nginx.Generation,
+	}
+
+	for _, name := range deploymentNames {
+		var deploy appsv1.Deployment
+		if err := r.Get(ctx, client.ObjectKey{Namespace: nginx.Namespace, Name: name}, &deploy); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return condition, err
+		}
+
+		for _, c := range deploy.Status.Conditions {
+			if c.Type == appsv1.DeploymentProgressing && c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded" {
+				condition.Status = metav1.ConditionTrue
+				condition.Reason = "ProgressDeadlineExceeded"
+				condition.Message = "Deployment " + deploy.Name + ": " + c.Message
+				return condition, nil
+			}
+		}
+	}
+
+	return condition, nil
+}
+
+// Conditionを設定し、内容に変更があった場合にtrueを返す
+func setCondition(conditions *[]metav1.Condition, condition metav1.Condition) bool {
+	current := meta.FindStatusCondition(*conditions, condition.Type)
+	if current != nil &&
+		current.Status == condition.Status &&
+		current.Reason == condition.Reason &&
+		current.Message == condition.Message &&
+		current.ObservedGeneration == condition.ObservedGeneration {
+		return false
+	}
+	meta.SetStatusCondition(conditions, condition)
+	return true
 }
 
 // OwnerReferenceの付与状況を確認し、Indexとして付与する値を決める関数
@@ -369,26 +1008,19 @@ func IndexByOwner(rawObj client.Object) []string {
 	var owner *metav1.OwnerReference
 
 	/*
-	  アサーションによりclient.Object型として渡されたrawObjの型判定を行い値を取得
+	  client.Object型として渡されたrawObj(Deployment/Service/ConfigMap/Job)から
+	  OwnerReferenceへのポインタを取得
 	*/
-	// rawObjがDeploymentの場合
-	if deployment, ok := rawObj.(*appsv1.Deployment); ok {
-		// OwnerReferenceへのポインタを取得
-		owner = metav1.GetControllerOf(deployment)
-	}
-
-	// rawObjがServiceの場合
-	if service, ok := rawObj.(*corev1.Service); ok {
-		// OwnerReferenceへのポインタを取得
-		owner = metav1.GetControllerOf(service)
-	}
+	owner = metav1.GetControllerOf(rawObj)
 
 	// OwnerReferenceが設定されていない場合
 	if owner == nil {
 		return nil
 	}
 
-	if owner.APIVersion != apiGVStr || owner.Kind != "Nginx" {
+	// v1で作成されたOwnerReferenceも対象にするためGroupのみ比較する
+	gv, err := schema.ParseGroupVersion(owner.APIVersion)
+	if err != nil || gv.Group != apiGroup || owner.Kind != "Nginx" {
 		return nil
 	}
 
@@ -423,10 +1055,45 @@ func (r *NginxReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Service{}, OwnerKey, IndexByOwner); err != nil {
 		return err
 	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.ConfigMap{}, OwnerKey, IndexByOwner); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &policyv1.PodDisruptionBudget{}, OwnerKey, IndexByOwner); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &batchv1.Job{}, OwnerKey, IndexByOwner); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &networkingv1.NetworkPolicy{}, OwnerKey, IndexByOwner); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.ServiceAccount{}, OwnerKey, IndexByOwner); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &nginxv2.Nginx{}, EnvSourceKey, IndexByEnvSource); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &nginxv2.Nginx{}, UpstreamServiceKey, IndexByUpstreamService); err != nil {
+		return err
+	}
 
+	// Shardingする場合は担当するShardのNginxのみWatchする
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&nginxv1.Nginx{}).
+		For(&nginxv2.Nginx{}, builder.WithPredicates(r.Shard.Predicate())).
 		Owns(&appsv1.Deployment{}). // Controllerに作成されるリソースを指定
 		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&policyv1.PodDisruptionBudget{}).
+		Owns(&batchv1.Job{}). // "nginx -t"による設定の検証が完了したらReconcileする
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&corev1.ServiceAccount{}).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(podToNginx)).
+		// upstreamのServiceの変更をNetworkPolicyのEgressに反映する
+		Watches(&source.Kind{Type: &corev1.Service{}}, handler.EnqueueRequestsFromMapFunc(r.upstreamServiceToNginx)).
+		// spec.env/envFromで参照しているConfigMap/Secretの変更でPodを再作成する
+		// ConfigMapはOwnsと同じInformerを使用し、Secretは内容をキャッシュせずmetadataのみWatchする
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.envSourceToNginx("ConfigMap"))).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.envSourceToNginx("Secret")), builder.OnlyMetadata).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
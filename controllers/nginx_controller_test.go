@@ -6,12 +6,14 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	nginxv1 "example.com/nginx-controller/api/v1"
+	nginxv2 "example.com/nginx-controller/api/v2"
 )
 
 // +kubebuilder:docs-gen:collapse=Imports
@@ -27,7 +29,7 @@ const (
 var _ = Describe("nginx controller", func() {
 
 	BeforeEach(func() {
-		err := k8sClient.DeleteAllOf(ctx, &nginxv1.Nginx{}, client.InNamespace(TestNamespace))
+		err := k8sClient.DeleteAllOf(ctx, &nginxv2.Nginx{}, client.InNamespace(TestNamespace))
 		Expect(err).NotTo(HaveOccurred())
 		err = k8sClient.DeleteAllOf(ctx, &appsv1.Deployment{}, client.InNamespace(TestNamespace))
 		Expect(err).NotTo(HaveOccurred())
@@ -114,7 +116,7 @@ var _ = Describe("nginx controller", func() {
 
 			// // Nginxを取得
 			// By("By checking the Nginx Status")
-			// updatedNginx := nginxv1.Nginx{}
+			// updatedNginx := nginxv2.Nginx{}
 			// Eventually(func() error {
 			// 	return k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestNginxName}, &updatedNginx)
 			// }).Should(Succeed())
@@ -125,17 +127,355 @@ var _ = Describe("nginx controller", func() {
 
 	})
 
+	Context("When using BlueGreen strategy", func() {
+
+		replicas := TestReplica
+
+		// BlueGreenの場合はblueのDeploymentとpreview用のServiceが作成されることの確認
+		It("Should create blue Deployment and preview Service", func() {
+
+			By("By creating a new Nginx with BlueGreen strategy")
+			nginx := newNginx(&replicas)
+			nginx.Spec.Strategy.Type = nginxv2.BlueGreenStrategyType
+			err := k8sClient.Create(ctx, nginx)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("By checking the blue Deployment")
+			deploy := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestDeploymentName + "-blue"}, &deploy)
+			}).Should(Succeed())
+			Expect(deploy.Spec.Template.Labels).Should(HaveKeyWithValue("color", "blue"))
+
+			By("By checking the Service routes traffic to blue")
+			service := corev1.Service{}
+			Eventually(func() map[string]string {
+				if err := k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestServiceName}, &service); err != nil {
+					return nil
+				}
+				return service.Spec.Selector
+			}).Should(Equal(map[string]string{"controller": TestNginxName, "color": "blue"}))
+
+			By("By checking the preview Service routes traffic to green")
+			preview := corev1.Service{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestServiceName + "-preview"}, &preview)
+			}).Should(Succeed())
+			Expect(preview.Spec.Selector).Should(Equal(map[string]string{"controller": TestNginxName, "color": "green"}))
+		})
+
+		// RollingUpdateからBlueGreenに切り替えた場合はblueがAvailableになるまで元のDeploymentを残すことの確認
+		It("Should keep the RollingUpdate Deployment until blue is available", func() {
+
+			By("By creating a new Nginx with RollingUpdate strategy")
+			nginx := newNginx(&replicas)
+			err := k8sClient.Create(ctx, nginx)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestDeploymentName}, &appsv1.Deployment{})
+			}).Should(Succeed())
+
+			By("By switching the Nginx to BlueGreen strategy")
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(nginx), nginx); err != nil {
+					return err
+				}
+				nginx.Spec.Strategy.Type = nginxv2.BlueGreenStrategyType
+				return k8sClient.Update(ctx, nginx)
+			}).Should(Succeed())
+
+			blue := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestDeploymentName + "-blue"}, &blue)
+			}).Should(Succeed())
+
+			// testenvではPodが作成されないのでblueはAvailableにならない
+			By("By checking the RollingUpdate Deployment is kept and the Service routes traffic to both")
+			Consistently(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestDeploymentName}, &appsv1.Deployment{})
+			}, time.Second).Should(Succeed())
+			service := corev1.Service{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestServiceName}, &service)).To(Succeed())
+			Expect(service.Spec.Selector).Should(Equal(map[string]string{"controller": TestNginxName}))
+
+			By("By making the blue Deployment available")
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(&blue), &blue); err != nil {
+					return err
+				}
+				blue.Status.ObservedGeneration = blue.Generation
+				blue.Status.Replicas = replicas
+				blue.Status.UpdatedReplicas = replicas
+				blue.Status.AvailableReplicas = replicas
+				return k8sClient.Status().Update(ctx, &blue)
+			}).Should(Succeed())
+
+			By("By checking the RollingUpdate Deployment is deleted and the Service routes traffic to blue")
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestDeploymentName}, &appsv1.Deployment{})
+				return apierrors.IsNotFound(err)
+			}).Should(BeTrue())
+			Eventually(func() map[string]string {
+				if err := k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestServiceName}, &service); err != nil {
+					return nil
+				}
+				return service.Spec.Selector
+			}).Should(Equal(map[string]string{"controller": TestNginxName, "color": "blue"}))
+		})
+
+		// 展開中のpreviewがない時に付与されたpromoteのAnnotationは削除されることの確認
+		It("Should remove the promote annotation when nothing is pending", func() {
+
+			By("By creating a new Nginx with BlueGreen strategy")
+			nginx := newNginx(&replicas)
+			nginx.Spec.Strategy.Type = nginxv2.BlueGreenStrategyType
+			Expect(k8sClient.Create(ctx, nginx)).To(Succeed())
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestDeploymentName + "-blue"}, &appsv1.Deployment{})
+			}).Should(Succeed())
+
+			By("By adding the promote annotation")
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(nginx), nginx); err != nil {
+					return err
+				}
+				nginx.Annotations = map[string]string{nginxv2.PromoteAnnotation: "true"}
+				return k8sClient.Update(ctx, nginx)
+			}).Should(Succeed())
+
+			By("By checking the annotation is removed without promotion")
+			Eventually(func() map[string]string {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(nginx), nginx); err != nil {
+					return nil
+				}
+				return nginx.Annotations
+			}).ShouldNot(HaveKey(nginxv2.PromoteAnnotation))
+			service := corev1.Service{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestServiceName}, &service)).To(Succeed())
+			Expect(service.Spec.Selector).Should(HaveKeyWithValue("color", "blue"))
+		})
+	})
+
+	Context("When overriding the pod template", func() {
+
+		replicas := TestReplica
+
+		// spec.podTemplate.annotationsから削除したAnnotationだけをPod Templateから削除することの確認
+		It("Should remove only the annotations removed from spec.podTemplate", func() {
+
+			By("By creating a new Nginx with pod template annotations")
+			nginx := newNginx(&replicas)
+			nginx.Spec.PodTemplate = &nginxv2.PodTemplateOverrides{
+				Annotations: map[string]string{"example.com/team": "web", "example.com/owner": "alice"},
+			}
+			Expect(k8sClient.Create(ctx, nginx)).To(Succeed())
+
+			deployment := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestDeploymentName}, &deployment)
+			}).Should(Succeed())
+			Expect(deployment.Spec.Template.Annotations).To(HaveKeyWithValue("example.com/team", "web"))
+			Expect(deployment.Spec.Template.Annotations).To(HaveKeyWithValue("example.com/owner", "alice"))
+
+			By("By restarting the Deployment with kubectl")
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(&deployment), &deployment); err != nil {
+					return err
+				}
+				deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = "2022-01-01T00:00:00Z"
+				return k8sClient.Update(ctx, &deployment)
+			}).Should(Succeed())
+
+			By("By removing an annotation from spec.podTemplate")
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(nginx), nginx); err != nil {
+					return err
+				}
+				delete(nginx.Spec.PodTemplate.Annotations, "example.com/owner")
+				return k8sClient.Update(ctx, nginx)
+			}).Should(Succeed())
+
+			By("By checking only the removed annotation is deleted")
+			Eventually(func() map[string]string {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(&deployment), &deployment); err != nil {
+					return nil
+				}
+				return deployment.Spec.Template.Annotations
+			}).ShouldNot(HaveKey("example.com/owner"))
+			Expect(deployment.Spec.Template.Annotations).To(HaveKeyWithValue("example.com/team", "web"))
+			Expect(deployment.Spec.Template.Annotations).To(HaveKeyWithValue("kubectl.kubernetes.io/restartedAt", "2022-01-01T00:00:00Z"))
+		})
+	})
+
+	Context("When referencing Secrets by env", func() {
+
+		replicas := TestReplica
+
+		// 参照しているSecretの有無をConditionに反映し、作成されたらPodを再作成することの確認
+		It("Should report the missing Secret and roll out when it is created", func() {
+
+			By("By creating a new Nginx referring to a missing Secret")
+			nginx := newNginx(&replicas)
+			nginx.Spec.EnvFrom = []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "env-token"}}}}
+			Expect(k8sClient.Create(ctx, nginx)).To(Succeed())
+
+			condition := func() metav1.ConditionStatus {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(nginx), nginx); err != nil {
+					return ""
+				}
+				for _, c := range nginx.Status.Conditions {
+					if c.Type == nginxv2.ConditionEnvSourcesFound {
+						return c.Status
+					}
+				}
+				return ""
+			}
+			Eventually(condition).Should(Equal(metav1.ConditionFalse))
+
+			deployment := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestDeploymentName}, &deployment)
+			}).Should(Succeed())
+			missing := deployment.Spec.Template.Annotations["nginx.my.domain/env-checksum"]
+
+			By("By creating the Secret")
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "env-token", Namespace: TestNamespace},
+				StringData: map[string]string{"TOKEN": "a"},
+			}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+			DeferCleanup(k8sClient.Delete, ctx, secret)
+
+			By("By checking the condition and the env checksum are updated")
+			Eventually(condition).Should(Equal(metav1.ConditionTrue))
+			Eventually(func() string {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(&deployment), &deployment); err != nil {
+					return ""
+				}
+				return deployment.Spec.Template.Annotations["nginx.my.domain/env-checksum"]
+			}).ShouldNot(Equal(missing))
+		})
+	})
+
+	Context("When validating the config", func() {
+
+		replicas := TestReplica
+
+		// nginx -tのJobにspec.podTemplateのVolumeをマウントし、検証中もServiceを作成することの確認
+		It("Should validate the config with the podTemplate volumes without blocking the Service", func() {
+			By("By creating a new Nginx with config referring to a Secret volume")
+			nginx := newNginx(&replicas)
+			nginx.Spec.Config = &nginxv2.NginxConfig{Content: "server {\n    listen 443 ssl;\n    ssl_certificate /etc/tls/tls.crt;\n    ssl_certificate_key /etc/tls/tls.key;\n}\n"}
+			nginx.Spec.PodTemplate = &nginxv2.PodTemplateOverrides{
+				Volumes:      []corev1.Volume{{Name: "tls", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "tls"}}}},
+				VolumeMounts: []corev1.VolumeMount{{Name: "tls", MountPath: "/etc/tls"}},
+			}
+			Expect(k8sClient.Create(ctx, nginx)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.DeleteAllOf(ctx, &batchv1.Job{}, client.InNamespace(TestNamespace),
+					client.PropagationPolicy(metav1.DeletePropagationBackground))).To(Succeed())
+			})
+
+			By("By checking the Job mounts the Secret volume")
+			var jobs batchv1.JobList
+			Eventually(func() ([]batchv1.Job, error) {
+				err := k8sClient.List(ctx, &jobs, client.InNamespace(TestNamespace), client.MatchingLabels{"nginx.my.domain/config-for": TestNginxName})
+				return jobs.Items, err
+			}).Should(HaveLen(1))
+			spec := jobs.Items[0].Spec.Template.Spec
+			Expect(spec.Containers).To(HaveLen(1))
+			Expect(spec.Containers[0].Command).To(Equal([]string{"nginx", "-t"}))
+			Expect(spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "tls", MountPath: "/etc/tls"}))
+			Expect(spec.Volumes).To(ContainElement(HaveField("Name", "tls")))
+			Expect(jobs.Items[0].Spec.Template.Labels).NotTo(HaveKey("controller"))
+
+			// testenvではJobが完了しないので検証中のまま
+			By("By checking the Service is created while the Deployment waits for the validation")
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestServiceName}, &corev1.Service{})
+			}).Should(Succeed())
+			Consistently(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: TestNamespace, Name: TestDeploymentName}, &appsv1.Deployment{})
+				return apierrors.IsNotFound(err)
+			}, time.Second).Should(BeTrue())
+		})
+	})
+
+	Context("When validating the config of a hardened Nginx", func() {
+
+		replicas := TestReplica
+
+		// readOnlyRootFilesystemでもnginx -tが書き込めるようにJobにemptyDirがマウントされることの確認
+		It("Should mount the writable emptyDirs in the nginx -t Job", func() {
+			By("By creating a new hardened Nginx with config")
+			nginx := newNginx(&replicas)
+			nginx.Spec.SecurityContext = &nginxv2.SecurityContextSpec{Hardened: true}
+			nginx.Spec.Config = &nginxv2.NginxConfig{Content: "server {\n    listen 8080;\n}\n"}
+			Expect(k8sClient.Create(ctx, nginx)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.DeleteAllOf(ctx, &batchv1.Job{}, client.InNamespace(TestNamespace),
+					client.PropagationPolicy(metav1.DeletePropagationBackground))).To(Succeed())
+			})
+
+			By("By checking the Job")
+			var jobs batchv1.JobList
+			Eventually(func() ([]batchv1.Job, error) {
+				err := k8sClient.List(ctx, &jobs, client.InNamespace(TestNamespace), client.MatchingLabels{"nginx.my.domain/config-for": TestNginxName})
+				return jobs.Items, err
+			}).Should(HaveLen(1))
+
+			spec := jobs.Items[0].Spec.Template.Spec
+			container := spec.Containers[0]
+			Expect(*container.SecurityContext.ReadOnlyRootFilesystem).To(BeTrue())
+			for _, mount := range []corev1.VolumeMount{
+				{Name: tmpVolume, MountPath: tmpMountPath},
+				{Name: cacheVolume, MountPath: cacheMountPath},
+				{Name: runVolume, MountPath: runMountPath},
+			} {
+				Expect(container.VolumeMounts).To(ContainElement(mount))
+				Expect(spec.Volumes).To(ContainElement(corev1.Volume{
+					Name:         mount.Name,
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+				}))
+			}
+		})
+	})
+
+	Context("When creating the ServiceAccount", func() {
+
+		replicas := TestReplica
+
+		// Controllerが作成していない既存のServiceAccountにOwnerReferenceを設定しないことの確認
+		It("Should not adopt an existing ServiceAccount", func() {
+			By("By creating a ServiceAccount not managed by Nginx")
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "existing-sa", Namespace: TestNamespace}}
+			Expect(k8sClient.Create(ctx, sa)).To(Succeed())
+			DeferCleanup(k8sClient.Delete, ctx, sa)
+
+			By("By creating a new Nginx with the same ServiceAccount name")
+			nginx := newNginx(&replicas)
+			nginx.Spec.ServiceAccount = &nginxv2.ServiceAccountSpec{Create: true, Name: "existing-sa"}
+			Expect(k8sClient.Create(ctx, nginx)).To(Succeed())
+
+			By("By checking the ServiceAccount is not owned by Nginx")
+			Consistently(func() ([]metav1.OwnerReference, error) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(sa), sa)
+				return sa.OwnerReferences, err
+			}, 3*time.Second).Should(BeEmpty())
+		})
+	})
+
 })
 
 // Nginxオブジェクトを生成する関数
-func newNginx(replicas *int32) *nginxv1.Nginx {
+func newNginx(replicas *int32) *nginxv2.Nginx {
 
-	return &nginxv1.Nginx{
+	return &nginxv2.Nginx{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      TestNginxName,
 			Namespace: TestNamespace,
 		},
-		Spec: nginxv1.NginxSpec{
+		Spec: nginxv2.NginxSpec{
 			Replicas: replicas,
 		},
 	}
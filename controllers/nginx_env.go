@@ -0,0 +1,247 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// spec.env/envFromで参照されているConfigMap/Secretの"<kind>/<name>"を値に持つNginxのIndex
+	EnvSourceKey = ".spec.envFrom"
+
+	// spec.env/envFromで参照しているConfigMap/Secretの内容のハッシュ値を記録するPod TemplateのAnnotation
+	// 内容が変更された場合はPodを再作成して環境変数を反映する
+	envChecksumAnnotation = "nginx.my.domain/env-checksum"
+)
+
+// spec.env/envFromで参照しているConfigMap/Secret(名前とNamespaceのみ設定したオブジェクト)
+// 同じオブジェクトを複数回参照している場合も1つだけ返す
+func EnvSourceObjects(nginx *nginxv2.Nginx) []client.Object {
+	var objs []client.Object
+	seen := make(map[string]bool)
+	add := func(obj client.Object, name string) {
+		obj.SetName(name)
+		obj.SetNamespace(nginx.Namespace)
+		if key := envSourceKey(obj); !seen[key] {
+			seen[key] = true
+			objs = append(objs, obj)
+		}
+	}
+
+	for _, env := range nginx.Spec.EnvFrom {
+		if env.ConfigMapRef != nil {
+			add(&corev1.ConfigMap{}, env.ConfigMapRef.Name)
+		}
+		if env.SecretRef != nil {
+			add(&corev1.Secret{}, env.SecretRef.Name)
+		}
+	}
+	for _, env := range nginx.Spec.Env {
+		if env.ValueFrom == nil {
+			continue
+		}
+		if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+			add(&corev1.ConfigMap{}, ref.Name)
+		}
+		if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+			add(&corev1.Secret{}, ref.Name)
+		}
+	}
+	return objs
+}
+
+// EnvSourceKeyのIndexの値("ConfigMap/<name>"または"Secret/<name>")
+func envSourceKey(obj client.Object) string {
+	switch obj.(type) {
+	case *corev1.Secret:
+		return "Secret/" + obj.GetName()
+	default:
+		return "ConfigMap/" + obj.GetName()
+	}
+}
+
+// EnvSourceKeyのIndexの値を返す
+func IndexByEnvSource(rawObj client.Object) []string {
+	nginx, ok := rawObj.(*nginxv2.Nginx)
+	if !ok {
+		return nil
+	}
+
+	var keys []string
+	for _, obj := range EnvSourceObjects(nginx) {
+		keys = append(keys, envSourceKey(obj))
+	}
+	return keys
+}
+
+// spec.env/envFromで参照しているConfigMap/Secretが変更された場合に参照しているNginxをReconcileする
+// Secretはmetadataのみをキャッシュするのでobjの型ではなくkind("ConfigMap"または"Secret")でIndexの値を生成する
+func (r *NginxReconciler) envSourceToNginx(kind string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		var nginxList nginxv2.NginxList
+		if err := r.List(context.Background(), &nginxList, client.InNamespace(obj.GetNamespace()), client.MatchingFields{EnvSourceKey: kind + "/" + obj.GetName()}); err != nil {
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(nginxList.Items))
+		for _, nginx := range nginxList.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&nginx)})
+		}
+		return requests
+	}
+}
+
+// spec.env/envFromで参照しているConfigMap/Secretのハッシュ値(参照していない場合は空)と存在を表すConditionを返す
+// ConfigMapはOwnsのInformerのキャッシュから、Secretはmetadataのみのキャッシュから取得する
+// 存在しないConfigMap/SecretのEventはConditionが変わった場合のみ記録する
+func (r *NginxReconciler) reconcileEnvChecksum(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx) (string, metav1.Condition, error) {
+	condition := metav1.Condition{
+		Type:               nginxv2.ConditionEnvSourcesFound,
+		ObservedGeneration: nginx.Generation,
+	}
+
+	checksum, warnings, err := envChecksum(nginx, func(obj client.Object) error {
+		if _, ok := obj.(*corev1.Secret); !ok {
+			return r.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		}
+		secret := &metav1.PartialObjectMetadata{}
+		secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+		if err := r.Get(ctx, client.ObjectKeyFromObject(obj), secret); err != nil {
+			return err
+		}
+		obj.SetResourceVersion(secret.ResourceVersion)
+		return nil
+	})
+	if err != nil {
+		log.Error(err, "Unable to fetch ConfigMap/Secret referenced by env")
+		return "", condition, err
+	}
+
+	if len(warnings) == 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Found"
+		condition.Message = "All ConfigMaps/Secrets referenced by env are found"
+		return checksum, condition, nil
+	}
+
+	condition.Status = metav1.ConditionFalse
+	condition.Reason = "NotFound"
+	condition.Message = strings.Join(warnings, "; ")
+	if current := meta.FindStatusCondition(nginx.Status.Conditions, condition.Type); current == nil ||
+		current.Status != condition.Status || current.Message != condition.Message {
+		for _, warning := range warnings {
+			r.Recorder.Event(nginx, corev1.EventTypeWarning, "EnvSourceNotFound", warning)
+		}
+	}
+	return checksum, condition, nil
+}
+
+// spec.env/envFromで参照しているConfigMap/Secretからハッシュ値を計算する
+// ConfigMapは内容、Secretは内容をキャッシュしないのでresourceVersionを使用する
+// 存在しないConfigMap/Secretは空として計算し、optionalでない場合は警告を返す
+// (作成された時点でハッシュ値が変わりPodが再作成される)
+func envChecksum(nginx *nginxv2.Nginx, getObject func(client.Object) error) (string, []string, error) {
+	objs := EnvSourceObjects(nginx)
+	if len(objs) == 0 {
+		return "", nil, nil
+	}
+
+	var warnings []string
+	data := make(map[string]interface{}, len(objs))
+	for _, obj := range objs {
+		key := envSourceKey(obj)
+		if err := getObject(obj); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return "", nil, err
+			}
+			if !envSourceOptional(nginx, key) {
+				warnings = append(warnings, fmt.Sprintf("%s referenced by env not found", key))
+			}
+			data[key] = nil
+			continue
+		}
+		switch o := obj.(type) {
+		case *corev1.ConfigMap:
+			data[key] = []interface{}{o.Data, o.BinaryData}
+		case *corev1.Secret:
+			data[key] = o.ResourceVersion
+		}
+	}
+
+	// mapのJSONはキーの順序で出力されるため、参照の順序が変わってもハッシュ値は変わらない
+	return computeHash(data), warnings, nil
+}
+
+// 全ての参照がoptionalの場合のみ、存在しなくてもよい
+func envSourceOptional(nginx *nginxv2.Nginx, key string) bool {
+	optional := func(ref *bool) bool { return ref != nil && *ref }
+	for _, env := range nginx.Spec.EnvFrom {
+		if env.ConfigMapRef != nil && "ConfigMap/"+env.ConfigMapRef.Name == key && !optional(env.ConfigMapRef.Optional) {
+			return false
+		}
+		if env.SecretRef != nil && "Secret/"+env.SecretRef.Name == key && !optional(env.SecretRef.Optional) {
+			return false
+		}
+	}
+	for _, env := range nginx.Spec.Env {
+		if env.ValueFrom == nil {
+			continue
+		}
+		if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil && "ConfigMap/"+ref.Name == key && !optional(ref.Optional) {
+			return false
+		}
+		if ref := env.ValueFrom.SecretKeyRef; ref != nil && "Secret/"+ref.Name == key && !optional(ref.Optional) {
+			return false
+		}
+	}
+	return true
+}
+
+// spec.env/envFromをnginxコンテナに設定し、参照しているConfigMap/Secretのハッシュ値をAnnotationに設定する
+func mutateEnv(template *corev1.PodTemplateSpec, container *corev1.Container, nginx *nginxv2.Nginx, checksum string) {
+	container.Env = nil
+	for _, env := range nginx.Spec.Env {
+		container.Env = append(container.Env, *env.DeepCopy())
+	}
+	defaultEnv(container.Env)
+	container.EnvFrom = nil
+	for _, env := range nginx.Spec.EnvFrom {
+		container.EnvFrom = append(container.EnvFrom, *env.DeepCopy())
+	}
+
+	if checksum != "" {
+		if template.Annotations == nil {
+			template.Annotations = make(map[string]string)
+		}
+		template.Annotations[envChecksumAnnotation] = checksum
+	} else {
+		delete(template.Annotations, envChecksumAnnotation)
+	}
+}
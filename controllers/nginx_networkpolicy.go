@@ -0,0 +1,276 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// spec.networkPolicy.upstreamsで参照されているServiceの"<namespace>/<name>"を値に持つNginxのIndex
+	UpstreamServiceKey = ".spec.networkPolicy.upstreams.service"
+
+	// Controllerが動いているPodのLabel(sidecarの/statusへの通信を許可する)
+	controllerPodLabel      = "control-plane"
+	controllerPodLabelValue = "controller-manager"
+)
+
+// NetworkPolicyの名前
+func (r *NginxReconciler) networkPolicyName(nginx *nginxv2.Nginx) string {
+	return r.Naming.NetworkPolicy(nginx.Name)
+}
+
+// spec.networkPolicyが指定されているかを確認する
+func hasNetworkPolicy(nginx *nginxv2.Nginx) bool {
+	return nginx.Spec.NetworkPolicy != nil
+}
+
+// upstreamのServiceの"<namespace>/<name>"(Namespaceが未指定の場合はNginxのNamespace)
+func upstreamServiceKey(nginx *nginxv2.Nginx, ref *nginxv2.UpstreamServiceReference) client.ObjectKey {
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = nginx.Namespace
+	}
+	return client.ObjectKey{Namespace: namespace, Name: ref.Name}
+}
+
+// UpstreamServiceKeyのIndexの値を返す
+func IndexByUpstreamService(rawObj client.Object) []string {
+	nginx, ok := rawObj.(*nginxv2.Nginx)
+	if !ok || !hasNetworkPolicy(nginx) {
+		return nil
+	}
+
+	var keys []string
+	for _, upstream := range nginx.Spec.NetworkPolicy.Upstreams {
+		if upstream.Service != nil {
+			keys = append(keys, upstreamServiceKey(nginx, upstream.Service).String())
+		}
+	}
+	return keys
+}
+
+// upstreamのServiceが変更された場合に参照しているNginxをReconcileする
+// (selectorやtargetPortの変更をEgressのルールに反映する)
+func (r *NginxReconciler) upstreamServiceToNginx(obj client.Object) []reconcile.Request {
+	var nginxList nginxv2.NginxList
+	if err := r.List(context.Background(), &nginxList, client.MatchingFields{UpstreamServiceKey: client.ObjectKeyFromObject(obj).String()}); err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(nginxList.Items))
+	for _, nginx := range nginxList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&nginx)})
+	}
+	return requests
+}
+
+// spec.networkPolicyに対応したNetworkPolicyを作成/更新
+func (r *NginxReconciler) CreateOrUpdateNetworkPolicy(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx) error {
+	log.Info("CreateOrUpdate NetworkPolicy for " + nginx.Name)
+
+	egress, warnings, err := upstreamEgressRules(nginx, func(key client.ObjectKey, service *corev1.Service) error {
+		return r.Get(ctx, key, service)
+	})
+	if err != nil {
+		log.Error(err, "Unable to fetch upstream Service")
+		return err
+	}
+	for _, warning := range warnings {
+		r.Recorder.Event(nginx, corev1.EventTypeWarning, "UpstreamNotResolved", warning)
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.networkPolicyName(nginx),
+			Namespace: nginx.Namespace,
+		},
+	}
+
+	operationResult, err := ctrl.CreateOrUpdate(ctx, r.Client, policy, func() error {
+		if err := r.mutateNetworkPolicy(policy, nginx, egress); err != nil {
+			log.Error(err, "Unable to set OwnerReference from Nginx to NetworkPolicy")
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err, "Unable to ensure networkpolicy is correct")
+		return err
+	}
+
+	log.Info("CreateOrUpdate NetworkPolicy for " + nginx.Name + ": " + string(operationResult))
+
+	return nil
+}
+
+// spec.networkPolicyの値をNetworkPolicyに設定する
+// Reconcileとrenderの両方から使用する
+//
+//	egress: upstreamへの通信を許可するルール(upstreamEgressRulesで生成する)
+func (r *NginxReconciler) mutateNetworkPolicy(policy *networkingv1.NetworkPolicy, nginx *nginxv2.Nginx, egress []networkingv1.NetworkPolicyEgressRule) error {
+	spec := nginx.Spec.NetworkPolicy
+
+	policy.ObjectMeta.Labels = nginxLabels(nginx)
+	// blue/greenの場合も両方のcolorのPodを対象にする
+	policy.Spec.PodSelector = metav1.LabelSelector{MatchLabels: map[string]string{"controller": nginx.Name}}
+
+	policy.Spec.Ingress = nil
+	if len(spec.Ingress) > 0 {
+		from := make([]networkingv1.NetworkPolicyPeer, 0, len(spec.Ingress))
+		for i := range spec.Ingress {
+			from = append(from, *spec.Ingress[i].DeepCopy())
+		}
+		policy.Spec.Ingress = append(policy.Spec.Ingress, networkingv1.NetworkPolicyIngressRule{
+			From:  from,
+			Ports: []networkingv1.NetworkPolicyPort{tcpPort(nginxPort(nginx))},
+		})
+	}
+	// HotReloadの場合はControllerからsidecarの/statusへの通信を許可する
+	if isHotReload(nginx) {
+		policy.Spec.Ingress = append(policy.Spec.Ingress, networkingv1.NetworkPolicyIngressRule{
+			From:  r.controllerPeers(),
+			Ports: []networkingv1.NetworkPolicyPort{tcpPort(reloaderPort)},
+		})
+	}
+
+	policy.Spec.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}
+	policy.Spec.Egress = nil
+	if len(spec.Upstreams) > 0 {
+		policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+		// upstreamのService名を解決するためにDNSへの通信を許可する
+		policy.Spec.Egress = append(policy.Spec.Egress, networkingv1.NetworkPolicyEgressRule{
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: protocolPtr(corev1.ProtocolUDP), Port: portPtr(intstr.FromInt(53))},
+				{Protocol: protocolPtr(corev1.ProtocolTCP), Port: portPtr(intstr.FromInt(53))},
+			},
+		})
+		policy.Spec.Egress = append(policy.Spec.Egress, egress...)
+	}
+
+	// ★NetworkPolicyにOwnerReferenceを設定
+	return ctrl.SetControllerReference(nginx, policy, r.Scheme)
+}
+
+// Controllerからの通信の送信元(ControllerNamespaceが未設定の場合は全ての送信元)
+func (r *NginxReconciler) controllerPeers() []networkingv1.NetworkPolicyPeer {
+	if r.ControllerNamespace == "" {
+		return nil
+	}
+	return []networkingv1.NetworkPolicyPeer{{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: r.ControllerNamespace}},
+		PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{controllerPodLabel: controllerPodLabelValue}},
+	}}
+}
+
+// spec.networkPolicy.upstreamsからEgressのルールを生成する
+// ServiceはselectorのPodとtargetPortへの通信を許可する(NetworkPolicyはService転送後のPodのPortに適用されるため)
+// 取得できないServiceやselectorのないServiceはルールを生成せず、理由をwarningsとして返す
+//
+//	getService: upstreamのServiceを取得する関数(存在しない場合はNotFoundのエラーを返す)
+func upstreamEgressRules(nginx *nginxv2.Nginx, getService func(client.ObjectKey, *corev1.Service) error) ([]networkingv1.NetworkPolicyEgressRule, []string, error) {
+	var rules []networkingv1.NetworkPolicyEgressRule
+	var warnings []string
+
+	for _, upstream := range nginx.Spec.NetworkPolicy.Upstreams {
+		ports := make([]networkingv1.NetworkPolicyPort, 0, len(upstream.Ports))
+		for i := range upstream.Ports {
+			ports = append(ports, *upstream.Ports[i].DeepCopy())
+		}
+
+		if upstream.CIDR != "" {
+			rules = append(rules, networkingv1.NetworkPolicyEgressRule{
+				To:    []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: upstream.CIDR}}},
+				Ports: ports,
+			})
+			continue
+		}
+		if upstream.Service == nil {
+			continue
+		}
+
+		key := upstreamServiceKey(nginx, upstream.Service)
+		var service corev1.Service
+		if err := getService(key, &service); err != nil {
+			if apierrors.IsNotFound(err) {
+				warnings = append(warnings, fmt.Sprintf("upstream Service %s not found", key))
+				continue
+			}
+			return nil, nil, err
+		}
+		if len(service.Spec.Selector) == 0 {
+			warnings = append(warnings, fmt.Sprintf("upstream Service %s has no selector, use cidr instead", key))
+			continue
+		}
+
+		peer := networkingv1.NetworkPolicyPeer{
+			PodSelector: &metav1.LabelSelector{MatchLabels: service.Spec.Selector},
+		}
+		if key.Namespace != nginx.Namespace {
+			peer.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: key.Namespace}}
+		}
+		if len(ports) == 0 {
+			ports = serviceTargetPorts(&service)
+		}
+		rules = append(rules, networkingv1.NetworkPolicyEgressRule{
+			To:    []networkingv1.NetworkPolicyPeer{peer},
+			Ports: ports,
+		})
+	}
+
+	return rules, warnings, nil
+}
+
+// ServiceのtargetPort(未指定の場合はport)
+func serviceTargetPorts(service *corev1.Service) []networkingv1.NetworkPolicyPort {
+	ports := make([]networkingv1.NetworkPolicyPort, 0, len(service.Spec.Ports))
+	for _, p := range service.Spec.Ports {
+		target := p.TargetPort
+		if target.Type == intstr.Int && target.IntVal == 0 {
+			target = intstr.FromInt(int(p.Port))
+		}
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: protocolPtr(protocol), Port: portPtr(target)})
+	}
+	return ports
+}
+
+func tcpPort(port int32) networkingv1.NetworkPolicyPort {
+	return networkingv1.NetworkPolicyPort{Protocol: protocolPtr(corev1.ProtocolTCP), Port: portPtr(intstr.FromInt(int(port)))}
+}
+
+func protocolPtr(protocol corev1.Protocol) *corev1.Protocol {
+	return &protocol
+}
+
+func portPtr(port intstr.IntOrString) *intstr.IntOrString {
+	return &port
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"example.com/nginx-controller/pkg/features"
+	"github.com/go-logr/logr"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// PodDisruptionBudgetの名前
+func (r *NginxReconciler) pdbName(nginx *nginxv2.Nginx) string {
+	return r.Naming.PodDisruptionBudget(nginx.Name)
+}
+
+// spec.disruptionBudgetが指定されているかを確認する
+// DisruptionBudgetのFeature Gateが無効な場合は作成済みのPodDisruptionBudgetも削除する
+func hasDisruptionBudget(nginx *nginxv2.Nginx) bool {
+	return nginx.Spec.DisruptionBudget != nil && features.Enabled(features.DisruptionBudget)
+}
+
+// spec.disruptionBudgetに対応したPodDisruptionBudgetを作成/更新
+// blue/greenの場合も両方のcolorのPodを対象にする
+func (r *NginxReconciler) CreateOrUpdatePodDisruptionBudget(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx) error {
+	log.Info("CreateOrUpdate PodDisruptionBudget for " + nginx.Name)
+
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.pdbName(nginx),
+			Namespace: nginx.Namespace,
+		},
+	}
+
+	operationResult, err := ctrl.CreateOrUpdate(ctx, r.Client, pdb, func() error {
+		if err := r.mutatePodDisruptionBudget(pdb, nginx); err != nil {
+			log.Error(err, "Unable to set OwnerReference from Nginx to PodDisruptionBudget")
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err, "Unable to ensure poddisruptionbudget is correct")
+		return err
+	}
+
+	log.Info("CreateOrUpdate PodDisruptionBudget for " + nginx.Name + ": " + string(operationResult))
+
+	return nil
+}
+
+// spec.disruptionBudgetの値をPodDisruptionBudgetに設定する
+// Reconcileとrenderの両方から使用する
+func (r *NginxReconciler) mutatePodDisruptionBudget(pdb *policyv1.PodDisruptionBudget, nginx *nginxv2.Nginx) error {
+	pdb.ObjectMeta.Labels = nginxLabels(nginx)
+	pdb.Spec.Selector = &metav1.LabelSelector{MatchLabels: nginxLabels(nginx)}
+	pdb.Spec.MinAvailable = nginx.Spec.DisruptionBudget.MinAvailable
+	pdb.Spec.MaxUnavailable = nginx.Spec.DisruptionBudget.MaxUnavailable
+
+	// ★PodDisruptionBudgetにOwnerReferenceを設定
+	return ctrl.SetControllerReference(nginx, pdb, r.Scheme)
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// Statusに表示するReadyでない、または古いImage/設定で動いているPodの最大数
+	maxReportedPods = 10
+
+	// HotReloadのsidecarへの問い合わせ全体の期限と同時に行う数
+	// Pod数が多い場合やsidecarに到達できない場合もReconcileをこの時間以上止めない
+	reloaderStatusTimeout     = 2 * time.Second
+	maxConcurrentReloaderReqs = 10
+)
+
+// Managerのキャッシュに保持するPodのSelector
+// Nginxリソースが管理するPodと"nginx -t"のJobのPod以外はキャッシュしない
+func podCacheSelector() cache.ObjectSelector {
+	selector, _ := labels.Parse("app in (nginx,nginx-config-check)")
+	return cache.ObjectSelector{Label: selector}
+}
+
+// ManagerのキャッシュをControllerが参照するオブジェクトに限定するSelector
+func CacheSelectors() cache.SelectorsByObject {
+	return cache.SelectorsByObject{
+		&corev1.Pod{}: podCacheSelector(),
+	}
+}
+
+// "controller: <Nginxリソース名>"のLabelが付与されたPodのうち、Serviceがトラフィックを流すPodを集計する
+// BlueGreenの場合はactiveColorのPodのみ、RollingUpdateの場合はcolorのLabelがないPodのみを対象にする
+// ReadyでないかImage/設定が最新でないPodは名前順に先頭のmaxReportedPods件のみ返す
+func (r *NginxReconciler) podsStatus(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx, activeColor string) (*nginxv2.PodsStatus, error) {
+	var podList corev1.PodList
+	if err := r.List(ctx, &podList, client.InNamespace(nginx.Namespace), client.MatchingLabels{"controller": nginx.Name}); err != nil {
+		return nil, err
+	}
+
+	var pods []corev1.Pod
+	for _, pod := range podList.Items {
+		if pod.Labels["color"] == activeColor {
+			pods = append(pods, pod)
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+
+	reloaders := fetchReloaderStatuses(ctx, log, pods)
+	status := &nginxv2.PodsStatus{}
+
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		podStatus := nginxv2.PodStatus{
+			Name:  pod.Name,
+			Node:  pod.Spec.NodeName,
+			Ready: podReady(&pod),
+		}
+		for _, c := range pod.Status.ContainerStatuses {
+			podStatus.Restarts += c.RestartCount
+		}
+		if container := findContainer(&pod.Spec, "nginx"); container != nil {
+			podStatus.Image = container.Image
+		}
+
+		// Restartの場合はPod TemplateのAnnotation、HotReloadの場合はsidecarから読み込んでいる設定を取得する
+		podStatus.ConfigHash = pod.Annotations[configHashAnnotation]
+		if reloader, ok := reloaders[pod.Name]; ok {
+			podStatus.ConfigHash = reloader.ConfigHash
+			if t, err := time.Parse(time.RFC3339, reloader.LastReloadTime); err == nil {
+				reloadTime := metav1.NewTime(t)
+				podStatus.LastReloadTime = &reloadTime
+			}
+		}
+
+		upToDate := podStatus.Image == nginxImage(nginx) && podStatus.ConfigHash == configContentHash(nginx)
+
+		status.Total++
+		if podStatus.Ready {
+			status.Ready++
+		}
+		if upToDate {
+			status.UpToDate++
+		}
+		if (!podStatus.Ready || !upToDate) && len(status.UnhealthyPods) < maxReportedPods {
+			status.UnhealthyPods = append(status.UnhealthyPods, podStatus)
+		}
+	}
+
+	return status, nil
+}
+
+// HotReloadのsidecarから各Podが読み込んでいる設定を取得する(取得できなかったPodは含まない)
+// 全てのPodへの問い合わせをreloaderStatusTimeoutの期限内で並行に行う
+func fetchReloaderStatuses(ctx context.Context, log logr.Logger, pods []corev1.Pod) map[string]*reloaderStatus {
+	ctx, cancel := context.WithTimeout(ctx, reloaderStatusTimeout)
+	defer cancel()
+
+	httpClient := &http.Client{}
+	sem := make(chan struct{}, maxConcurrentReloaderReqs)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	result := make(map[string]*reloaderStatus)
+
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil || findContainer(&pod.Spec, reloaderContainer) == nil || pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			reloader, err := fetchReloaderStatus(ctx, httpClient, pod.Status.PodIP)
+			if err != nil {
+				log.Info("Unable to fetch reload status from " + pod.Name + ": " + err.Error())
+				return
+			}
+			mu.Lock()
+			result[pod.Name] = reloader
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return result
+}
+
+// PodのReady Conditionを確認する
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// Podの変更を"controller"のLabelに対応するNginxのReconcileに変換する
+// (再起動回数などDeploymentのStatusに現れない変更もStatusに反映するため)
+func podToNginx(obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	if labels["app"] != "nginx" || labels["controller"] == "" {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: labels["controller"]},
+	}}
+}
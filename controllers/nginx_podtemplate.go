@@ -0,0 +1,217 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+	"strings"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// spec.podTemplate.annotationsからPod Templateに設定したAnnotationのキー(","区切り)をDeploymentに記録するAnnotation
+const podAnnotationsAnnotation = "nginx.my.domain/pod-annotations"
+
+// Controllerが管理するPod TemplateのAnnotation(spec.podTemplate.annotationsより優先する)
+// kubectl rollout restartのAnnotationはPodを再作成しないように残す
+var managedPodAnnotations = []string{
+	configHashAnnotation,
+	envChecksumAnnotation,
+	nginxv2.RestartedAtAnnotation,
+	"kubectl.kubernetes.io/restartedAt",
+}
+
+// Controllerが追加するコンテナとVolume(spec.podTemplateの値では置き換えない)
+var (
+	managedContainers = []string{"nginx", reloaderContainer}
+	managedVolumes    = []string{configVolume, runVolume, tmpVolume, cacheVolume}
+)
+
+// spec.podTemplate.labelsにControllerのLabelを重ねたPodのLabel
+// selectorに使用するLabelはControllerの値を優先する
+func podLabels(nginx *nginxv2.Nginx, labels map[string]string) map[string]string {
+	result := make(map[string]string)
+	if pt := nginx.Spec.PodTemplate; pt != nil {
+		for k, v := range pt.Labels {
+			result[k] = v
+		}
+	}
+	for k, v := range labels {
+		result[k] = v
+	}
+	return result
+}
+
+// spec.podTemplate.annotationsのうちPod Templateに設定するキー(Controllerが管理するキーを除く)を名前順に返す
+func podTemplateAnnotationKeys(nginx *nginxv2.Nginx) []string {
+	var keys []string
+	if pt := nginx.Spec.PodTemplate; pt != nil {
+		for k := range pt.Annotations {
+			if !containsString(managedPodAnnotations, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 前回spec.podTemplate.annotationsから設定したAnnotationのうち、specから削除されたものをPod Templateから削除し、
+// 今回設定するキーをDeploymentのAnnotationに記録する
+// kubectl rollout restartなどController以外が追加したAnnotationは削除しない
+// (mutatePodTemplateの前に呼び出す)
+func mutatePodAnnotationKeys(deploy *appsv1.Deployment, nginx *nginxv2.Nginx) {
+	keys := podTemplateAnnotationKeys(nginx)
+	if previous := deploy.Annotations[podAnnotationsAnnotation]; previous != "" {
+		for _, key := range strings.Split(previous, ",") {
+			if !containsString(keys, key) {
+				delete(deploy.Spec.Template.Annotations, key)
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		delete(deploy.Annotations, podAnnotationsAnnotation)
+		return
+	}
+	if deploy.Annotations == nil {
+		deploy.Annotations = make(map[string]string)
+	}
+	deploy.Annotations[podAnnotationsAnnotation] = strings.Join(keys, ",")
+}
+
+// spec.podTemplateのAnnotation、sidecar、init container、Volumeを生成したPod Templateに反映する
+// sidecar、init container、Volumeはspec.podTemplateから削除された値も反映するため、Controllerが管理する値以外は作り直す
+// (Annotationは他から追加された値を残すため、削除された値はmutatePodAnnotationKeysで削除する)
+// spec.podTemplateの値はControllerの値の後に追加し、Reconcileの度に順序が変わらないようにする
+// (Controllerのコンテナ、Volume、security contextを全て設定した後に呼び出す)
+func mutatePodTemplateOverrides(template *corev1.PodTemplateSpec, container *corev1.Container, nginx *nginxv2.Nginx) {
+	pt := nginx.Spec.PodTemplate
+	if pt == nil {
+		pt = &nginxv2.PodTemplateOverrides{}
+	}
+
+	for _, key := range podTemplateAnnotationKeys(nginx) {
+		if template.Annotations == nil {
+			template.Annotations = make(map[string]string)
+		}
+		template.Annotations[key] = pt.Annotations[key]
+	}
+
+	// nginxコンテナへのVolumeMount
+	var mounts []corev1.VolumeMount
+	for _, mount := range container.VolumeMounts {
+		if containsString(managedVolumes, mount.Name) {
+			mounts = append(mounts, mount)
+		}
+	}
+	container.VolumeMounts = append(mounts, pt.VolumeMounts...)
+
+	var volumes []corev1.Volume
+	for _, volume := range template.Spec.Volumes {
+		if containsString(managedVolumes, volume.Name) {
+			volumes = append(volumes, volume)
+		}
+	}
+	for _, volume := range pt.Volumes {
+		volume := *volume.DeepCopy()
+		defaultVolume(&volume)
+		volumes = append(volumes, volume)
+	}
+	template.Spec.Volumes = volumes
+
+	// containerのポインタはここで無効になるので最後に置き換える
+	var containers []corev1.Container
+	for _, c := range template.Spec.Containers {
+		if containsString(managedContainers, c.Name) {
+			containers = append(containers, c)
+		}
+	}
+	template.Spec.Containers = append(containers, overrideContainers(pt.Containers, nginx)...)
+	template.Spec.InitContainers = overrideContainers(pt.InitContainers, nginx)
+}
+
+// spec.podTemplateのコンテナに初期値を設定する
+// securityContextが未指定の場合はspec.securityContextの値を設定する
+func overrideContainers(containers []corev1.Container, nginx *nginxv2.Nginx) []corev1.Container {
+	var result []corev1.Container
+	for _, c := range containers {
+		c := *c.DeepCopy()
+		if c.SecurityContext == nil {
+			c.SecurityContext = containerSecurityContext(nginx)
+		}
+		defaultContainer(&c)
+		result = append(result, c)
+	}
+	return result
+}
+
+// API Serverが設定する初期値をコンテナに設定する
+// spec.podTemplateの値をそのまま設定すると、Reconcileの度にDeploymentとの差分になるため
+func defaultContainer(c *corev1.Container) {
+	if c.TerminationMessagePath == "" {
+		c.TerminationMessagePath = corev1.TerminationMessagePathDefault
+	}
+	if c.TerminationMessagePolicy == "" {
+		c.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	}
+	if c.ImagePullPolicy == "" {
+		_, tag, found := strings.Cut(c.Image[strings.LastIndex(c.Image, "/")+1:], ":")
+		if !strings.Contains(c.Image, "@") && (!found || tag == "latest") {
+			c.ImagePullPolicy = corev1.PullAlways
+		} else {
+			c.ImagePullPolicy = corev1.PullIfNotPresent
+		}
+	}
+	for i := range c.Ports {
+		if c.Ports[i].Protocol == "" {
+			c.Ports[i].Protocol = corev1.ProtocolTCP
+		}
+	}
+	defaultEnv(c.Env)
+	for _, probe := range []*corev1.Probe{c.ReadinessProbe, c.LivenessProbe, c.StartupProbe} {
+		nginxv2.DefaultProbe(probe)
+	}
+}
+
+func defaultEnv(env []corev1.EnvVar) {
+	for i := range env {
+		if ref := env[i].ValueFrom; ref != nil && ref.FieldRef != nil && ref.FieldRef.APIVersion == "" {
+			ref.FieldRef.APIVersion = "v1"
+		}
+	}
+}
+
+// API Serverが設定する初期値をVolumeに設定する
+func defaultVolume(volume *corev1.Volume) {
+	switch {
+	case volume.ConfigMap != nil && volume.ConfigMap.DefaultMode == nil:
+		mode := corev1.ConfigMapVolumeSourceDefaultMode
+		volume.ConfigMap.DefaultMode = &mode
+	case volume.Secret != nil && volume.Secret.DefaultMode == nil:
+		mode := corev1.SecretVolumeSourceDefaultMode
+		volume.Secret.DefaultMode = &mode
+	case volume.Projected != nil && volume.Projected.DefaultMode == nil:
+		mode := corev1.ProjectedVolumeSourceDefaultMode
+		volume.Projected.DefaultMode = &mode
+	case volume.DownwardAPI != nil && volume.DownwardAPI.DefaultMode == nil:
+		mode := corev1.DownwardAPIVolumeSourceDefaultMode
+		volume.DownwardAPI.DefaultMode = &mode
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"example.com/nginx-controller/pkg/features"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// 設定の変更を検知してnginxに設定を再読み込みさせるsidecar
+	reloaderContainer = "config-reloader"
+
+	// sidecarが最後に読み込んだ設定を返すPort
+	reloaderPort = nginxv2.ReloaderPort
+
+	// sidecarが状態を書き込むVolume(nginxコンテナにもマウントしてhardenedの/var/runと共用する)
+	runVolume    = "run"
+	runMountPath = "/var/run"
+
+	// HotReloadの場合に各Podの設定を確認する間隔
+	reloadStatusInterval = 30 * time.Second
+)
+
+// sidecarで実行するスクリプト
+// nginxコンテナとPID Namespaceを共有し、sidecarからnginxのmaster processにSIGHUPを送って設定を再読み込みする
+// nginxのpidファイルの場所はImageによって異なるので参照せず、/procからmaster processを探す
+// (sidecar自身のnginxはpidファイルで除外する。sidecarの書き込みは全て常にマウントするrunVolumeに行う)
+// 最後に読み込んだ設定のハッシュ値と時刻をreloaderPortの/statusで返す
+// ※コマンド中の"$(...)"はkubeletに環境変数として展開されないように"$$(...)"と記述する
+var reloaderScript = fmt.Sprintf(`set -eu
+conf=%[1]s/%[2]s
+state=%[3]s/nginx-reloader
+mkdir -p "$state"
+
+reload_nginx() {
+  self=$$(cat "$state/reloader.pid")
+  for dir in /proc/[0-9]*; do
+    pid=$${dir#/proc/}
+    [ "$pid" = "$self" ] && continue
+    case "$$(tr '\0' ' ' < "$dir/cmdline" 2>/dev/null)" in
+      "nginx: master process"*)
+        kill -HUP "$pid"
+        return 0
+        ;;
+    esac
+  done
+  echo "nginx master process not found" >&2
+  return 1
+}
+
+write_status() {
+  printf '{"configHash":"%%s","lastReloadTime":"%%s"}' "$1" "$$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)" > "$state/status.json.tmp"
+  mv "$state/status.json.tmp" "$state/status.json"
+}
+
+cat > "$state/reloader.conf" <<EOF
+pid $state/reloader.pid;
+error_log stderr;
+events {}
+http {
+  access_log off;
+  server {
+    listen %[4]d;
+    location = /status {
+      default_type application/json;
+      alias $state/status.json;
+    }
+  }
+}
+EOF
+nginx -c "$state/reloader.conf"
+
+last=$$(md5sum "$conf" | cut -d' ' -f1)
+write_status "$last"
+
+while true; do
+  sleep 5
+  current=$$(md5sum "$conf" | cut -d' ' -f1)
+  if [ "$current" = "$last" ]; then
+    continue
+  fi
+  if nginx -t && reload_nginx; then
+    echo "reloaded config $current"
+    last=$current
+    write_status "$last"
+  fi
+done
+`, configMountPath, configFileName, runMountPath, reloaderPort)
+
+// spec.config.reloadStrategyがHotReloadかを確認する
+// HotReloadのFeature Gateが無効な場合はRestartとして扱う
+func isHotReload(nginx *nginxv2.Nginx) bool {
+	return hasConfig(nginx) && nginx.Spec.Config.ReloadStrategy == nginxv2.HotReloadReloadStrategy && features.Enabled(features.HotReload)
+}
+
+// spec.config.contentのハッシュ値(sidecarがmd5sumで計算する値と同じ)
+func configContentHash(nginx *nginxv2.Nginx) string {
+	if !hasConfig(nginx) {
+		return ""
+	}
+	sum := md5.Sum([]byte(nginx.Spec.Config.Content))
+	return hex.EncodeToString(sum[:])
+}
+
+// HotReloadの場合にPod Templateへsidecarと共有Volumeを設定する
+// HotReloadでない場合は設定済みのsidecarを削除する
+func mutateReloader(spec *corev1.PodSpec, container *corev1.Container, nginx *nginxv2.Nginx) {
+	if !isHotReload(nginx) {
+		spec.ShareProcessNamespace = nil
+		removeContainer(spec, reloaderContainer)
+		removeVolume(spec, runVolume)
+		removeVolumeMount(container, runVolume)
+		return
+	}
+
+	shareProcessNamespace := true
+	spec.ShareProcessNamespace = &shareProcessNamespace
+
+	setVolume(spec, corev1.Volume{
+		Name:         runVolume,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	setVolumeMount(container, corev1.VolumeMount{Name: runVolume, MountPath: runMountPath})
+
+	// findContainerのポインタはappendで無効になるのでnginxコンテナの設定が終わってから追加する
+	reloader := findContainer(spec, reloaderContainer)
+	if reloader == nil {
+		spec.Containers = append(spec.Containers, corev1.Container{Name: reloaderContainer})
+		reloader = &spec.Containers[len(spec.Containers)-1]
+	}
+	reloader.Image = nginxImage(nginx)
+	reloader.Command = []string{"/bin/sh", "-c", reloaderScript}
+	reloader.Ports = []corev1.ContainerPort{{
+		Name:          "reloader",
+		ContainerPort: reloaderPort,
+		Protocol:      corev1.ProtocolTCP,
+	}}
+	setVolumeMount(reloader, corev1.VolumeMount{Name: configVolume, MountPath: configMountPath})
+	setVolumeMount(reloader, corev1.VolumeMount{Name: runVolume, MountPath: runMountPath})
+}
+
+// 名前が一致するContainerを削除する
+func removeContainer(spec *corev1.PodSpec, name string) {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == name {
+			spec.Containers = append(spec.Containers[:i], spec.Containers[i+1:]...)
+			return
+		}
+	}
+}
+
+// sidecarの/statusのレスポンス
+type reloaderStatus struct {
+	ConfigHash     string `json:"configHash"`
+	LastReloadTime string `json:"lastReloadTime"`
+}
+
+// PodのsidecarにHTTPでアクセスして読み込んでいる設定を取得する
+func fetchReloaderStatus(ctx context.Context, httpClient *http.Client, podIP string) (*reloaderStatus, error) {
+	url := "http://" + podIP + ":" + strconv.Itoa(int(reloaderPort)) + "/status"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var status reloaderStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
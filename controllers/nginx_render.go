@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Render はNginxリソースからReconcileが作成する子リソースを生成する
+// クラスタには接続せず、Reconcileと同じmutate関数でspecを組み立てる
+// liveに種類と名前が一致するオブジェクトがある場合はそのコピーに変更を適用する(kubectl nginx diffで使用)
+//
+//	Deployment: blue/greenの場合はstatus.activeColorのDeploymentのみ(previewはspecの変更時にのみ作成される)
+//	            spec.env/envFromで参照しているConfigMap/Secretはliveに含まれるもののみハッシュ値に反映する
+//	Service: blue/greenの場合はpreviewのServiceも含む
+//	ConfigMap: spec.configが指定されている場合の検証済みの設定(検証用のConfigMapとJobは含まない)
+//	PodDisruptionBudget: spec.disruptionBudgetが指定されている場合
+//	ServiceAccount: spec.serviceAccount.createがtrueの場合
+//	NetworkPolicy: spec.networkPolicyが指定されている場合(upstreamのServiceはliveに含まれるもののみEgressに反映する)
+func (r *NginxReconciler) Render(nginx *nginxv2.Nginx, live ...client.Object) ([]client.Object, error) {
+	deploymentName := r.Naming.Deployment(nginx.Name)
+	labels := nginxLabels(nginx)
+	selector := map[string]string{"controller": nginx.Name}
+	color := ""
+	if isBlueGreen(nginx) {
+		color = currentActiveColor(nginx)
+		deploymentName = r.colorDeploymentName(nginx, color)
+		labels = colorLabels(nginx, color)
+		selector["color"] = color
+	}
+
+	checksum, _, err := envChecksum(nginx, func(obj client.Object) error {
+		if !copyLiveObject(live, obj) {
+			return apierrors.NewNotFound(corev1.Resource(envSourceKey(obj)), obj.GetName())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deploy := &appsv1.Deployment{ObjectMeta: renderObjectMeta(nginx, deploymentName)}
+	copyLiveObject(live, deploy)
+	if err := r.mutateDeployment(deploy, nginx, labels, nginxReplicas(nginx), checksum); err != nil {
+		return nil, err
+	}
+
+	service := &corev1.Service{ObjectMeta: renderObjectMeta(nginx, r.Naming.Service(nginx.Name))}
+	copyLiveObject(live, service)
+	if err := r.mutateService(service, nginx, selector, nginx.Spec.Service); err != nil {
+		return nil, err
+	}
+
+	objs := []client.Object{deploy, service}
+	if isBlueGreen(nginx) {
+		preview := &corev1.Service{ObjectMeta: renderObjectMeta(nginx, r.Naming.Service(nginx.Name, "preview"))}
+		copyLiveObject(live, preview)
+		previewSelector := map[string]string{"controller": nginx.Name, "color": otherColor(color)}
+		if err := r.mutateService(preview, nginx, previewSelector, nginxv2.ServiceSpec{Type: corev1.ServiceTypeClusterIP}); err != nil {
+			return nil, err
+		}
+		objs = append(objs, preview)
+	}
+	if hasConfig(nginx) {
+		configMap := &corev1.ConfigMap{ObjectMeta: renderObjectMeta(nginx, r.configMapName(nginx))}
+		copyLiveObject(live, configMap)
+		if err := r.mutateConfigMap(configMap, nginx, configValidationHash(nginx)); err != nil {
+			return nil, err
+		}
+		objs = append(objs, configMap)
+	}
+	if hasDisruptionBudget(nginx) {
+		pdb := &policyv1.PodDisruptionBudget{ObjectMeta: renderObjectMeta(nginx, r.pdbName(nginx))}
+		copyLiveObject(live, pdb)
+		if err := r.mutatePodDisruptionBudget(pdb, nginx); err != nil {
+			return nil, err
+		}
+		objs = append(objs, pdb)
+	}
+	if hasServiceAccount(nginx) {
+		sa := &corev1.ServiceAccount{ObjectMeta: renderObjectMeta(nginx, r.serviceAccountName(nginx))}
+		copyLiveObject(live, sa)
+		if err := r.mutateServiceAccount(sa, nginx); err != nil {
+			return nil, err
+		}
+		objs = append(objs, sa)
+	}
+	if hasNetworkPolicy(nginx) {
+		egress, _, err := upstreamEgressRules(nginx, func(key client.ObjectKey, service *corev1.Service) error {
+			service.Name, service.Namespace = key.Name, key.Namespace
+			if !copyLiveObject(live, service) {
+				return apierrors.NewNotFound(corev1.Resource("services"), key.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		policy := &networkingv1.NetworkPolicy{ObjectMeta: renderObjectMeta(nginx, r.networkPolicyName(nginx))}
+		copyLiveObject(live, policy)
+		if err := r.mutateNetworkPolicy(policy, nginx, egress); err != nil {
+			return nil, err
+		}
+		objs = append(objs, policy)
+	}
+
+	// YAMLとして出力できるようにapiVersion/kindを設定する
+	for _, obj := range objs {
+		gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get GroupVersionKind of %T: %w", obj, err)
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+	}
+
+	return objs, nil
+}
+
+// Nginxと同じNamespaceに作成するオブジェクトのmetadata
+func renderObjectMeta(nginx *nginxv2.Nginx, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: nginx.Namespace}
+}
+
+// liveから種類と名前が一致するオブジェクトをobjにコピーする(一致するものがない場合はfalseを返す)
+func copyLiveObject(live []client.Object, obj client.Object) bool {
+	for _, l := range live {
+		if reflect.TypeOf(l) != reflect.TypeOf(obj) || l.GetName() != obj.GetName() || l.GetNamespace() != obj.GetNamespace() {
+			continue
+		}
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(l.DeepCopyObject()).Elem())
+		return true
+	}
+	return false
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	nginxv1 "example.com/nginx-controller/api/v1"
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"example.com/nginx-controller/pkg/features"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ControllerRevisionに保存したspecのハッシュ値を記録するLabel
+	specHashLabel = "nginx.my.domain/spec-hash"
+
+	// ControllerRevisionに保存したspecのAPI versionを記録するLabel(未設定の場合はv1)
+	specVersionLabel = "nginx.my.domain/spec-version"
+
+	// spec.specHistoryLimitが未設定の場合の値
+	defaultSpecHistoryLimit = int32(5)
+)
+
+// spec.rollbackToまたはAnnotationで指定されたrollback先のrevisionを返す
+func rollbackRevision(nginx *nginxv2.Nginx) (int64, bool) {
+	if nginx.Spec.RollbackTo != nil {
+		return *nginx.Spec.RollbackTo, true
+	}
+	if v, ok := nginx.Annotations[nginxv2.RollbackToAnnotation]; ok {
+		revision, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return -1, true // 存在しないrevisionとして扱う
+		}
+		return revision, true
+	}
+	return 0, false
+}
+
+// 指定したrevisionのControllerRevisionに保存されたspecでNginxを更新する
+// rollback後はspec.rollbackToとAnnotationを削除する
+// SpecRollbackのFeature Gateが無効な場合はrollbackせず、Eventを記録してspec.rollbackToとAnnotationを削除する
+func (r *NginxReconciler) rollback(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx, revision int64) error {
+	if !features.Enabled(features.SpecRollback) {
+		log.Info("Ignore the rollback of " + nginx.Name + " because the SpecRollback feature gate is disabled")
+		r.Recorder.Eventf(nginx, corev1.EventTypeWarning, "RollbackDisabled", "Unable to roll back to revision %d: the %s feature gate is disabled", revision, features.SpecRollback)
+		return r.clearRollback(ctx, log, nginx)
+	}
+
+	revisions, err := r.listRevisions(ctx, nginx)
+	if err != nil {
+		return err
+	}
+
+	var target *appsv1.ControllerRevision
+	for i := range revisions {
+		if revisions[i].Revision == revision {
+			target = &revisions[i]
+		}
+	}
+
+	if target != nil {
+		spec, err := decodeRevisionSpec(target)
+		if err != nil {
+			log.Error(err, "Unable to decode ControllerRevision "+target.Name)
+			return err
+		}
+		log.Info("Roll back " + nginx.Name + " to revision " + strconv.FormatInt(revision, 10))
+		nginx.Spec = spec
+		r.Recorder.Eventf(nginx, corev1.EventTypeNormal, "RolledBack", "Rolled back to revision %d", revision)
+	} else {
+		log.Info("Revision " + strconv.FormatInt(revision, 10) + " not found for " + nginx.Name)
+		r.Recorder.Eventf(nginx, corev1.EventTypeWarning, "RollbackRevisionNotFound", "Unable to find revision %d", revision)
+	}
+
+	return r.clearRollback(ctx, log, nginx)
+}
+
+// spec.rollbackToとAnnotationを削除してNginxを更新する
+func (r *NginxReconciler) clearRollback(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx) error {
+	nginx.Spec.RollbackTo = nil
+	delete(nginx.Annotations, nginxv2.RollbackToAnnotation)
+	if err := r.Update(ctx, nginx); err != nil {
+		log.Error(err, "Unable to update Nginx")
+		return err
+	}
+
+	return nil
+}
+
+// Nginxが所有するControllerRevisionをrevisionの昇順で取得する
+func (r *NginxReconciler) listRevisions(ctx context.Context, nginx *nginxv2.Nginx) ([]appsv1.ControllerRevision, error) {
+	var revisionList appsv1.ControllerRevisionList
+	if err := r.List(ctx, &revisionList, client.InNamespace(nginx.Namespace), client.MatchingLabels{"controller": nginx.Name}); err != nil {
+		return nil, err
+	}
+
+	var revisions []appsv1.ControllerRevision
+	for _, revision := range revisionList.Items {
+		if metav1.IsControlledBy(&revision, nginx) {
+			revisions = append(revisions, revision)
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+
+	return revisions, nil
+}
+
+// 現在のspecをControllerRevisionとして保存し、spec.specHistoryLimitを超えた古いものを削除する
+// 同じspecが既に保存されている場合はそのrevisionを最新の番号に更新する
+func (r *NginxReconciler) recordRevision(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx) error {
+	spec := nginx.Spec.DeepCopy()
+	spec.RollbackTo = nil
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	hash := computeHash(spec)
+
+	revisions, err := r.listRevisions(ctx, nginx)
+	if err != nil {
+		return err
+	}
+
+	next := int64(1)
+	if len(revisions) > 0 {
+		next = revisions[len(revisions)-1].Revision + 1
+	}
+
+	var current *appsv1.ControllerRevision
+	for i := range revisions {
+		if revisions[i].Labels[specHashLabel] == hash {
+			current = &revisions[i]
+		}
+	}
+
+	switch {
+	case current == nil:
+		revision := &appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      r.Naming.Revision(nginx.Name, hash),
+				Namespace: nginx.Namespace,
+				Labels:    nginxLabels(nginx),
+			},
+			Data:     runtime.RawExtension{Raw: raw},
+			Revision: next,
+		}
+		revision.Labels[specHashLabel] = hash
+		revision.Labels[specVersionLabel] = nginxv2.GroupVersion.Version
+		if err := ctrl.SetControllerReference(nginx, revision, r.Scheme); err != nil {
+			log.Error(err, "Unable to set OwnerReference from Nginx to ControllerRevision")
+		}
+		if err := r.Create(ctx, revision); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				return nil // cacheに反映される前に再度Reconcileされた場合
+			}
+			log.Error(err, "Unable to create ControllerRevision")
+			return err
+		}
+		log.Info("Create ControllerRevision " + revision.Name + " (revision " + strconv.FormatInt(next, 10) + ")")
+		revisions = append(revisions, *revision)
+	case current.Revision != next-1:
+		// rollbackなどで過去のspecに戻った場合は最新のrevisionとして扱う
+		current.Revision = next
+		if err := r.Update(ctx, current); err != nil {
+			log.Error(err, "Unable to update ControllerRevision")
+			return err
+		}
+		sort.Slice(revisions, func(i, j int) bool {
+			return revisions[i].Revision < revisions[j].Revision
+		})
+	}
+
+	limit := defaultSpecHistoryLimit
+	if nginx.Spec.SpecHistoryLimit != nil {
+		limit = *nginx.Spec.SpecHistoryLimit
+	}
+	for i := 0; i < len(revisions)-int(limit); i++ {
+		if err := r.Delete(ctx, &revisions[i]); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "Faild to delete old ControllerRevision")
+			return err
+		}
+		log.Info("Delete old ControllerRevision resource: " + revisions[i].Name)
+	}
+
+	return nil
+}
+
+// ControllerRevisionの一覧からStatusに表示するrevisionの一覧と現在のrevisionを生成する
+func (r *NginxReconciler) specRevisions(ctx context.Context, nginx *nginxv2.Nginx) ([]nginxv2.SpecRevision, int64, error) {
+	revisions, err := r.listRevisions(ctx, nginx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	spec := nginx.Spec.DeepCopy()
+	spec.RollbackTo = nil
+	hash := computeHash(spec)
+
+	var specRevisions []nginxv2.SpecRevision
+	var current int64
+	for _, revision := range revisions {
+		stored, _ := decodeRevisionSpec(&revision)
+		specRevisions = append(specRevisions, nginxv2.SpecRevision{
+			Revision:          revision.Revision,
+			Name:              revision.Name,
+			Image:             stored.Image,
+			CreationTimestamp: revision.CreationTimestamp,
+		})
+		if revision.Labels[specHashLabel] == hash {
+			current = revision.Revision
+		}
+	}
+
+	return specRevisions, current, nil
+}
+
+// ControllerRevisionに保存されたspecを取得する
+// v2の導入前に保存されたspecはv1としてデコードしてv2に変換する
+func decodeRevisionSpec(revision *appsv1.ControllerRevision) (nginxv2.NginxSpec, error) {
+	if revision.Labels[specVersionLabel] == nginxv2.GroupVersion.Version {
+		var spec nginxv2.NginxSpec
+		err := json.Unmarshal(revision.Data.Raw, &spec)
+		return spec, err
+	}
+
+	var v1 nginxv1.Nginx
+	if err := json.Unmarshal(revision.Data.Raw, &v1.Spec); err != nil {
+		return nginxv2.NginxSpec{}, err
+	}
+	var v2 nginxv2.Nginx
+	err := v1.ConvertTo(&v2)
+	return v2.Spec, err
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+)
+
+const (
+	// hardenedの場合にreadOnlyRootFilesystemでも書き込めるようにするVolume
+	// /var/runはHotReloadのsidecarが使用するrunVolumeと共用する
+	tmpVolume      = "tmp"
+	tmpMountPath   = "/tmp"
+	cacheVolume    = "cache"
+	cacheMountPath = "/var/cache/nginx"
+)
+
+// spec.securityContextをPodと全てのコンテナに設定する
+// hardenedの場合は書き込みが必要なディレクトリにemptyDirをマウントする
+// (Podの全てのコンテナを設定するので、コンテナを追加した後に呼び出す)
+func mutateSecurityContext(spec *corev1.PodSpec, nginx *nginxv2.Nginx) {
+	// PodのsecurityContextはAPI Serverが空の値を設定するので、未指定の場合は空の値を残して不要な更新を防ぐ
+	// (Pod Templateのハッシュ値も変わらないようにnilの場合はnilのままにする)
+	if pod := podSecurityContext(nginx); pod != nil {
+		spec.SecurityContext = pod
+	} else if spec.SecurityContext != nil && !apiequality.Semantic.DeepEqual(*spec.SecurityContext, corev1.PodSecurityContext{}) {
+		spec.SecurityContext = nil
+	}
+
+	container := containerSecurityContext(nginx)
+	for i := range spec.Containers {
+		spec.Containers[i].SecurityContext = container.DeepCopy()
+	}
+
+	if !nginx.Spec.IsHardened() {
+		for _, name := range []string{tmpVolume, cacheVolume} {
+			removeVolume(spec, name)
+			for i := range spec.Containers {
+				removeVolumeMount(&spec.Containers[i], name)
+			}
+		}
+		return
+	}
+
+	// sidecarを含む全てのコンテナに同じVolumeをマウントする
+	mounts := []corev1.VolumeMount{
+		{Name: tmpVolume, MountPath: tmpMountPath},
+		{Name: cacheVolume, MountPath: cacheMountPath},
+		{Name: runVolume, MountPath: runMountPath},
+	}
+	for _, mount := range mounts {
+		setVolume(spec, corev1.Volume{
+			Name:         mount.Name,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		for i := range spec.Containers {
+			setVolumeMount(&spec.Containers[i], mount)
+		}
+	}
+}
+
+// hardenedの設定にspec.securityContext.podを重ねたPodのsecurity context(どちらも未指定の場合はnil)
+func podSecurityContext(nginx *nginxv2.Nginx) *corev1.PodSecurityContext {
+	sc := nginx.Spec.SecurityContext
+	if sc == nil {
+		return nil
+	}
+
+	var result *corev1.PodSecurityContext
+	if sc.Hardened {
+		runAsNonRoot := true
+		result = &corev1.PodSecurityContext{
+			RunAsNonRoot:   &runAsNonRoot,
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		}
+	}
+	if sc.Pod != nil {
+		if result == nil {
+			result = &corev1.PodSecurityContext{}
+		}
+		overlay(result, sc.Pod)
+	}
+	return result
+}
+
+// hardenedの設定にspec.securityContext.containerを重ねたコンテナのsecurity context(どちらも未指定の場合はnil)
+func containerSecurityContext(nginx *nginxv2.Nginx) *corev1.SecurityContext {
+	sc := nginx.Spec.SecurityContext
+	if sc == nil {
+		return nil
+	}
+
+	var result *corev1.SecurityContext
+	if sc.Hardened {
+		runAsNonRoot := true
+		readOnlyRootFilesystem := true
+		allowPrivilegeEscalation := false
+		result = &corev1.SecurityContext{
+			RunAsNonRoot:             &runAsNonRoot,
+			ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+			AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		}
+	}
+	if sc.Container != nil {
+		if result == nil {
+			result = &corev1.SecurityContext{}
+		}
+		overlay(result, sc.Container)
+	}
+	return result
+}
+
+// srcで指定された項目でdstを上書きする(security contextの項目は全てomitemptyなのでJSONで重ねる)
+func overlay(dst, src interface{}) {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return
+	}
+	_ = json.Unmarshal(b, dst)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// spec.serviceAccount.createがtrueで、ControllerがServiceAccountを作成するかを確認する
+func hasServiceAccount(nginx *nginxv2.Nginx) bool {
+	return nginx.Spec.ServiceAccount != nil && nginx.Spec.ServiceAccount.Create
+}
+
+// PodのServiceAccountの名前(spec.serviceAccountが未指定の場合は空)
+func (r *NginxReconciler) serviceAccountName(nginx *nginxv2.Nginx) string {
+	sa := nginx.Spec.ServiceAccount
+	if sa == nil {
+		return ""
+	}
+	if sa.Name != "" {
+		return sa.Name
+	}
+	return r.Naming.ServiceAccount(nginx.Name)
+}
+
+// spec.serviceAccount.automountToken(未指定の場合はfalse)
+func automountServiceAccountToken(nginx *nginxv2.Nginx) *bool {
+	automount := false
+	if nginx.Spec.ServiceAccount.AutomountToken != nil {
+		automount = *nginx.Spec.ServiceAccount.AutomountToken
+	}
+	return &automount
+}
+
+// Pod TemplateにServiceAccountを設定する
+// spec.serviceAccountが未指定の場合はNamespaceのdefaultのServiceAccountを使用する
+func (r *NginxReconciler) mutateServiceAccountName(spec *corev1.PodSpec, nginx *nginxv2.Nginx) {
+	if nginx.Spec.ServiceAccount == nil {
+		// DeprecatedServiceAccountはAPI ServerがserviceAccountNameと同じ値を設定するので合わせて戻す
+		spec.ServiceAccountName = ""
+		spec.DeprecatedServiceAccount = ""
+		spec.AutomountServiceAccountToken = nil
+		return
+	}
+
+	spec.ServiceAccountName = r.serviceAccountName(nginx)
+	spec.DeprecatedServiceAccount = spec.ServiceAccountName
+	spec.AutomountServiceAccountToken = automountServiceAccountToken(nginx)
+}
+
+// spec.serviceAccountに対応したServiceAccountを作成/更新
+func (r *NginxReconciler) CreateOrUpdateServiceAccount(ctx context.Context, log logr.Logger, nginx *nginxv2.Nginx) error {
+	log.Info("CreateOrUpdate ServiceAccount for " + nginx.Name)
+
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.serviceAccountName(nginx),
+			Namespace: nginx.Namespace,
+		},
+	}
+
+	operationResult, err := ctrl.CreateOrUpdate(ctx, r.Client, sa, func() error {
+		// Controllerが作成していない既存のServiceAccount(defaultなど)を引き継ぐと
+		// OwnerReferenceによりNginxの削除時にGarbage Collectionで削除されてしまうのでエラーにする
+		if !sa.CreationTimestamp.IsZero() && !metav1.IsControlledBy(sa, nginx) {
+			err := fmt.Errorf("ServiceAccount %s already exists and is not managed by Nginx %s", sa.Name, nginx.Name)
+			r.Recorder.Event(nginx, corev1.EventTypeWarning, "ServiceAccountConflict", err.Error())
+			return err
+		}
+		return r.mutateServiceAccount(sa, nginx)
+	})
+	if err != nil {
+		log.Error(err, "Unable to ensure serviceaccount is correct")
+		return err
+	}
+
+	log.Info("CreateOrUpdate ServiceAccount for " + nginx.Name + ": " + string(operationResult))
+
+	return nil
+}
+
+// spec.serviceAccountの値をServiceAccountに設定する
+// Reconcileとrenderの両方から使用する
+func (r *NginxReconciler) mutateServiceAccount(sa *corev1.ServiceAccount, nginx *nginxv2.Nginx) error {
+	sa.ObjectMeta.Labels = nginxLabels(nginx)
+	sa.ObjectMeta.Annotations = nil
+	if len(nginx.Spec.ServiceAccount.Annotations) > 0 {
+		sa.ObjectMeta.Annotations = make(map[string]string, len(nginx.Spec.ServiceAccount.Annotations))
+		for k, v := range nginx.Spec.ServiceAccount.Annotations {
+			sa.ObjectMeta.Annotations[k] = v
+		}
+	}
+	sa.AutomountServiceAccountToken = automountServiceAccountToken(nginx)
+
+	// ★ServiceAccountにOwnerReferenceを設定
+	return ctrl.SetControllerReference(nginx, sa, r.Scheme)
+}
@@ -28,12 +28,14 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
-	nginxv1 "example.com/nginx-controller/api/v1"
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"example.com/nginx-controller/pkg/features"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -59,6 +61,9 @@ var _ = BeforeSuite(func() {
 	// Contextの作成
 	ctx, cancel = context.WithCancel(context.TODO())
 
+	// Alphaの機能も含めてテストするため全てのFeature Gateを有効にする
+	Expect(features.Set("BlueGreen=true,HotReload=true,SpecRollback=true,DisruptionBudget=true")).To(Succeed())
+
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
 		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
@@ -71,7 +76,7 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(cfg).NotTo(BeNil())
 
-	err = nginxv1.AddToScheme(scheme.Scheme)
+	err = nginxv2.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:scheme
@@ -82,13 +87,15 @@ var _ = BeforeSuite(func() {
 
 	// Controller Managerの起動
 	k8sManager, err := ctrl.NewManager(cfg, ctrl.Options{
-		Scheme: scheme.Scheme,
+		Scheme:   scheme.Scheme,
+		NewCache: cache.BuilderWithOptions(cache.Options{SelectorsByObject: CacheSelectors()}),
 	})
 	Expect(err).ToNot(HaveOccurred())
 
 	err = (&NginxReconciler{
-		Client: k8sManager.GetClient(),
-		Scheme: k8sManager.GetScheme(),
+		Client:   k8sManager.GetClient(),
+		Scheme:   k8sManager.GetScheme(),
+		Recorder: k8sManager.GetEventRecorderFor("nginx-controller"),
 	}).SetupWithManager(k8sManager)
 
 	Expect(err).ToNot(HaveOccurred())
@@ -0,0 +1,278 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// namespaced-rbacはcontroller-genが生成したClusterRole(config/rbac/role.yaml)から
+// --watch-namespacesで動かすためのRole/RoleBindingとパッチを生成する
+//
+//	go run ./hack/namespaced-rbac --namespaces=team-a,team-b --output-dir=config/namespaced
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const header = "# Code generated by hack/namespaced-rbac. DO NOT EDIT.\n"
+
+// Namespaceに閉じないリソース(ClusterRoleのまま読み取り権限のみ付与する)
+var clusterScoped = map[string]bool{
+	"namespaces":     true,
+	"nginxclasses":   true,
+	"nodes":          true,
+	"storageclasses": true,
+}
+
+func main() {
+	var namespaces, rolePath, outputDir, prefix, systemNamespace string
+	flag.StringVar(&namespaces, "namespaces", "", "The comma separated list of namespaces watched by the controller.")
+	flag.StringVar(&rolePath, "role", "config/rbac/role.yaml", "The ClusterRole generated by controller-gen.")
+	flag.StringVar(&outputDir, "output-dir", "config/namespaced", "The directory to write the manifests to.")
+	flag.StringVar(&prefix, "name-prefix", "nginx-controller-", "The namePrefix of config/default.")
+	flag.StringVar(&systemNamespace, "system-namespace", "nginx-controller-system", "The namespace of config/default.")
+	flag.Parse()
+
+	if err := run(splitNamespaces(namespaces), rolePath, outputDir, prefix, systemNamespace); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func splitNamespaces(value string) []string {
+	var namespaces []string
+	for _, ns := range strings.Split(value, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+func run(namespaces []string, rolePath, outputDir, prefix, systemNamespace string) error {
+	if len(namespaces) == 0 {
+		return fmt.Errorf("--namespaces is required")
+	}
+	for _, ns := range namespaces {
+		if msgs := validation.IsDNS1123Label(ns); len(msgs) > 0 {
+			return fmt.Errorf("invalid namespace %q: %s", ns, strings.Join(msgs, ", "))
+		}
+	}
+
+	data, err := os.ReadFile(rolePath)
+	if err != nil {
+		return err
+	}
+	var clusterRole rbacv1.ClusterRole
+	if err := yaml.Unmarshal(bytes.TrimPrefix(data, []byte("---\n")), &clusterRole); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", rolePath, err)
+	}
+
+	docs, err := rbacManifests(clusterRole.Rules, namespaces, prefix, systemNamespace)
+	if err != nil {
+		return err
+	}
+	if err := writeManifests(filepath.Join(outputDir, "rbac.yaml"), docs...); err != nil {
+		return err
+	}
+
+	if err := writeManifests(filepath.Join(outputDir, "manager_watch_namespaces_patch.yaml"), managerPatch(namespaces)); err != nil {
+		return err
+	}
+
+	return writeManifests(filepath.Join(outputDir, "webhook_namespace_selector_patch.yaml"), webhookPatches(namespaces)...)
+}
+
+// Namespaceごとの権限をRole/RoleBindingに、クラスタ全体のリソースの権限をClusterRole/ClusterRoleBindingに分ける
+func rbacManifests(rules []rbacv1.PolicyRule, namespaces []string, prefix, systemNamespace string) ([]interface{}, error) {
+	var namespaced, cluster []rbacv1.PolicyRule
+	for _, rule := range rules {
+		var nsResources, clusterResources []string
+		for _, resource := range rule.Resources {
+			if clusterScoped[resource] {
+				clusterResources = append(clusterResources, resource)
+			} else {
+				nsResources = append(nsResources, resource)
+			}
+		}
+		if len(nsResources) > 0 {
+			r := *rule.DeepCopy()
+			r.Resources = nsResources
+			namespaced = append(namespaced, r)
+		}
+		if len(clusterResources) > 0 {
+			r := *rule.DeepCopy()
+			r.Resources = clusterResources
+			r.Verbs = readOnly(r.Verbs)
+			cluster = append(cluster, r)
+		}
+	}
+	if len(namespaced) == 0 {
+		return nil, fmt.Errorf("no namespaced rules found")
+	}
+
+	subjects := []rbacv1.Subject{{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      prefix + "controller-manager",
+		Namespace: systemNamespace,
+	}}
+	roleName := prefix + "manager-role"
+
+	var docs []interface{}
+	if len(cluster) > 0 {
+		clusterRoleName := prefix + "manager-cluster-role"
+		docs = append(docs,
+			&rbacv1.ClusterRole{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+				ObjectMeta: metav1.ObjectMeta{Name: clusterRoleName},
+				Rules:      cluster,
+			},
+			&rbacv1.ClusterRoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: prefix + "manager-cluster-rolebinding"},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: clusterRoleName},
+				Subjects:   subjects,
+			},
+		)
+	}
+
+	for _, ns := range namespaces {
+		docs = append(docs, role(roleName, ns, namespaced), roleBinding(prefix+"manager-rolebinding", ns, roleName, subjects))
+	}
+
+	// WebhookはController自身のNamespaceにあるデフォルト値のConfigMapを参照する
+	if !contains(namespaces, systemNamespace) {
+		defaultsRoleName := prefix + "manager-defaults-role"
+		docs = append(docs,
+			role(defaultsRoleName, systemNamespace, []rbacv1.PolicyRule{{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				Verbs:     []string{"get"},
+			}}),
+			roleBinding(prefix+"manager-defaults-rolebinding", systemNamespace, defaultsRoleName, subjects),
+		)
+	}
+
+	return docs, nil
+}
+
+func role(name, namespace string, rules []rbacv1.PolicyRule) *rbacv1.Role {
+	return &rbacv1.Role{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Rules:      rules,
+	}
+}
+
+func roleBinding(name, namespace, roleName string, subjects []rbacv1.Subject) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: roleName},
+		Subjects:   subjects,
+	}
+}
+
+func readOnly(verbs []string) []string {
+	var result []string
+	for _, verb := range verbs {
+		switch verb {
+		case "get", "list", "watch":
+			result = append(result, verb)
+		}
+	}
+	return result
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Managerに--watch-namespacesを渡すパッチ(config/manager/manager.yamlのDeploymentに対するStrategic Merge Patch)
+// 型付きの構造体ではゼロ値のフィールドがnullとして出力され、パッチで削除されてしまうためmapで記述する
+func managerPatch(namespaces []string) interface{} {
+	return map[string]interface{}{
+		"apiVersion": appsv1.SchemeGroupVersion.String(),
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "controller-manager", "namespace": "system"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{map[string]interface{}{
+						"name": "manager",
+						"env": []interface{}{map[string]interface{}{
+							"name":  "WATCH_NAMESPACES",
+							"value": strings.Join(namespaces, ","),
+						}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// Webhookの対象もWatchするNamespaceに限定するパッチ
+// (それ以外のNamespaceのServiceなどは参照する権限がないため)
+func webhookPatches(namespaces []string) []interface{} {
+	selector := &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      corev1.LabelMetadataName,
+			Operator: metav1.LabelSelectorOpIn,
+			Values:   namespaces,
+		}},
+	}
+	patch := func(kind, name, webhook string) interface{} {
+		return map[string]interface{}{
+			"apiVersion": admissionregistrationv1.SchemeGroupVersion.String(),
+			"kind":       kind,
+			"metadata":   map[string]interface{}{"name": name},
+			"webhooks":   []interface{}{map[string]interface{}{"name": webhook, "namespaceSelector": selector}},
+		}
+	}
+	return []interface{}{
+		patch("MutatingWebhookConfiguration", "mutating-webhook-configuration", "mnginx.kb.io"),
+		patch("ValidatingWebhookConfiguration", "validating-webhook-configuration", "vnginx.kb.io"),
+	}
+}
+
+func writeManifests(path string, docs ...interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	for _, doc := range docs {
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
@@ -17,22 +17,41 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	configv1alpha1 "example.com/nginx-controller/api/config/v1alpha1"
 	nginxv1 "example.com/nginx-controller/api/v1"
+	nginxv2 "example.com/nginx-controller/api/v2"
 	"example.com/nginx-controller/controllers"
+	"example.com/nginx-controller/pkg/controllerconfig"
+	"example.com/nginx-controller/pkg/features"
+	"example.com/nginx-controller/pkg/health"
+	"example.com/nginx-controller/pkg/naming"
+	"example.com/nginx-controller/pkg/render"
+	"example.com/nginx-controller/pkg/sharding"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,18 +64,68 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(nginxv1.AddToScheme(scheme))
+	utilruntime.Must(nginxv2.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	// "render"の場合はControllerを起動せずにNginxの子リソースを出力する
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := runRender(os.Args[2:]); err != nil {
+			if err != flag.ErrHelp {
+				fmt.Fprintln(os.Stderr, "error:", err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	var configFile string
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	var protectedNamespaces string
+	var watchNamespaces string
+	var shard sharding.Shard
+	var featureGates string
+	namingPolicy := naming.Default()
+	flag.StringVar(&configFile, "config", "",
+		"The path to the ControllerConfig file. Flags set explicitly take precedence over the file.")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", configv1alpha1.DefaultMetricsBindAddress, "The address the metric endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", configv1alpha1.DefaultHealthProbeBindAddress, "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&namingPolicy.MaxNameLength, "max-nginx-name-length", naming.DefaultMaxNameLength,
+		"The maximum length of Nginx names accepted by the validating webhook (0 means no limit other than the label value limit).")
+	flag.StringVar(&namingPolicy.DeploymentTemplate, "deployment-name-template", naming.DefaultDeploymentTemplate,
+		"The template of the Deployment names managed by Nginx. "+naming.NamePlaceholder+" is replaced with the Nginx name.")
+	flag.StringVar(&namingPolicy.ServiceTemplate, "service-name-template", naming.DefaultServiceTemplate,
+		"The template of the Service names managed by Nginx. "+naming.NamePlaceholder+" is replaced with the Nginx name.")
+	flag.StringVar(&namingPolicy.ConfigMapTemplate, "configmap-name-template", naming.DefaultConfigMapTemplate,
+		"The template of the ConfigMap names managed by Nginx. "+naming.NamePlaceholder+" is replaced with the Nginx name.")
+	flag.StringVar(&namingPolicy.ConfigCheckJobTemplate, "config-check-job-name-template", naming.DefaultConfigCheckJobTemplate,
+		"The template of the Job names running nginx -t. "+naming.NamePlaceholder+" is replaced with the Nginx name.")
+	flag.StringVar(&namingPolicy.PodDisruptionBudgetTemplate, "pdb-name-template", naming.DefaultPodDisruptionBudgetTemplate,
+		"The template of the PodDisruptionBudget names managed by Nginx. "+naming.NamePlaceholder+" is replaced with the Nginx name.")
+	flag.StringVar(&namingPolicy.NetworkPolicyTemplate, "networkpolicy-name-template", naming.DefaultNetworkPolicyTemplate,
+		"The template of the NetworkPolicy names managed by Nginx. "+naming.NamePlaceholder+" is replaced with the Nginx name.")
+	flag.StringVar(&namingPolicy.ServiceAccountTemplate, "serviceaccount-name-template", naming.DefaultServiceAccountTemplate,
+		"The template of the ServiceAccount names managed by Nginx. "+naming.NamePlaceholder+" is replaced with the Nginx name.")
+	flag.StringVar(&namingPolicy.RevisionTemplate, "revision-name-template", naming.DefaultRevisionTemplate,
+		"The template of the ControllerRevision names saving the spec of Nginx. "+naming.NamePlaceholder+" is replaced with the Nginx name.")
+	flag.StringVar(&protectedNamespaces, "protected-namespace-selector", "environment=production",
+		"The label selector of namespaces where deleting Nginx whose Service has a LoadBalancer address is refused (empty disables it).")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", os.Getenv("WATCH_NAMESPACES"),
+		"The namespaces watched by the controller, as a comma separated list (e.g. \"team-a,team-b\") "+
+			"or a label selector on namespaces (e.g. \"nginx.my.domain/managed=true\"). Empty means all namespaces. "+
+			"Defaults to the WATCH_NAMESPACES environment variable.")
+	flag.IntVar(&shard.ID, "shard-id", 0,
+		"The shard of Nginx resources reconciled by this controller (0 to shard-count - 1).")
+	flag.IntVar(&shard.Count, "shard-count", 1,
+		"The number of shards. Nginx resources are assigned to shards by the hash of their namespace/name.")
+	flag.StringVar(&featureGates, "feature-gates", "",
+		"A set of key=value pairs that enable or disable features (e.g. \"BlueGreen=false\"). Known features: "+strings.Join(features.Known(), ", "))
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,16 +134,84 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	// var resyncPeriod = time.Second * 30
+	if err := namingPolicy.Validate(); err != nil {
+		setupLog.Error(err, "invalid naming policy")
+		os.Exit(1)
+	}
+
+	if err := shard.Validate(); err != nil {
+		setupLog.Error(err, "invalid shard")
+		os.Exit(1)
+	}
+	if shard.Enabled() {
+		setupLog.Info("reconciling a shard of Nginx resources", "shardID", shard.ID, "shardCount", shard.Count)
+	}
+
+	// 設定ファイルを読み込み、明示的に指定されたフラグで上書きする
+	ctrlConfig := controllerconfig.Default()
+	if configFile != "" {
+		var err error
+		if ctrlConfig, err = controllerconfig.Load(configFile); err != nil {
+			setupLog.Error(err, "unable to load the controller config", "path", configFile)
+			os.Exit(1)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "metrics-bind-address":
+			ctrlConfig.Metrics.BindAddress = metricsAddr
+		case "health-probe-bind-address":
+			ctrlConfig.Health.HealthProbeBindAddress = probeAddr
+		}
+	})
+	// Feature Gateは設定ファイル、--feature-gatesの順に設定する
+	if err := features.SetFromMap(ctrlConfig.FeatureGates); err != nil {
+		setupLog.Error(err, "invalid feature gates in the controller config")
+		os.Exit(1)
+	}
+	if err := features.Set(featureGates); err != nil {
+		setupLog.Error(err, "invalid feature gates")
+		os.Exit(1)
+	}
+	if watchNamespaces == "" {
+		watchNamespaces = controllerconfig.WatchNamespaces(ctrlConfig)
+	}
+	nginxv2.SetBuiltinImage(ctrlConfig.DefaultImage)
+
+	config := ctrl.GetConfigOrDie()
+
+	// Watch対象のNamespaceを限定する場合はManagerのCache(Informer、OwnerKeyのIndex)もそのNamespaceに限定する
+	namespaces, err := resolveWatchNamespaces(config, watchNamespaces)
+	if err != nil {
+		setupLog.Error(err, "invalid watch namespaces")
+		os.Exit(1)
+	}
+	baseCache := cache.New
+	if len(namespaces) > 0 {
+		setupLog.Info("restricting the cache to namespaces", "namespaces", namespaces)
+		baseCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+	// Podなど数の多いリソースはControllerが参照するものだけをキャッシュする
+	newCache := func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		opts.SelectorsByObject = controllers.CacheSelectors()
+		return baseCache(config, opts)
+	}
+
+	var syncPeriod *time.Duration
+	if ctrlConfig.SyncPeriod != nil {
+		syncPeriod = &ctrlConfig.SyncPeriod.Duration
+	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		// SyncPeriod:             &resyncPeriod, // UpdateFuncを実行しcacheをsyncする間隔(default 10H)
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
+		SyncPeriod:             syncPeriod, // UpdateFuncを実行しcacheをsyncする間隔(default 10H)
 		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
+		NewCache:               newCache,
+		MetricsBindAddress:     ctrlConfig.Metrics.BindAddress,
+		Port:                   ctrlConfig.Webhook.Port,
+		CertDir:                ctrlConfig.Webhook.CertDir,
+		HealthProbeBindAddress: ctrlConfig.Health.HealthProbeBindAddress,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "31c31aa8.my.domain",
+		LeaderElectionID:       shard.LeaderElectionID("31c31aa8.my.domain"), // Shardごとに1つのControllerがLeaderになる
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -93,29 +230,83 @@ func main() {
 	}
 
 	if err = (&controllers.NginxReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("nginx-controller"),
+		Naming:   namingPolicy,
+		Shard:    shard,
+
+		MaxConcurrentReconciles: ctrlConfig.MaxConcurrentReconciles,
+		ControllerNamespace:     os.Getenv("POD_NAMESPACE"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Nginx")
 		os.Exit(1)
 	}
 	// Webhook
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		// Controllerと同じNamespaceのConfigMapをクラスタ全体のデフォルト値として参照する
+		nginxv2.DefaultsNamespace = os.Getenv("POD_NAMESPACE")
+		nginxv2.NamingPolicy = namingPolicy
+		if protectedNamespaces != "" {
+			selector, err := labels.Parse(protectedNamespaces)
+			if err != nil {
+				setupLog.Error(err, "invalid protected namespace selector")
+				os.Exit(1)
+			}
+			nginxv2.ProtectedNamespaceSelector = selector
+		}
 		if err = (&nginxv1.Nginx{}).SetupWebhookWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "Nginx")
 			os.Exit(1)
 		}
+		if err = (&nginxv2.Nginx{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Nginx")
+			os.Exit(1)
+		}
 	}
 	//+kubebuilder:scaffold:builder
 
+	// 設定ファイルの変更を監視し、再起動せずに反映できる設定(defaultImage)を反映する
+	if configFile != "" {
+		watcher := controllerconfig.NewWatcher(configFile, ctrlConfig, func(c *configv1alpha1.ControllerConfig) {
+			nginxv2.SetBuiltinImage(c.DefaultImage)
+		}, ctrl.Log.WithName("config"))
+		if err := mgr.Add(watcher); err != nil {
+			setupLog.Error(err, "unable to watch the controller config")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+
+	// /readyz?verboseで失敗している項目が分かるよう、確認する項目ごとに名前を付けて登録する
+	apiServerChecker, err := health.APIServer(config)
+	if err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	readyzChecks := map[string]healthz.Checker{
+		"cache-sync": health.CacheSynced(mgr.GetCache()),
+		"apiserver":  apiServerChecker,
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		certDir := ctrlConfig.Webhook.CertDir
+		if certDir == "" {
+			// controller-runtimeのWebhookサーバーのデフォルト
+			certDir = filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
+		}
+		readyzChecks["webhook-server"] = mgr.GetWebhookServer().StartedChecker()
+		readyzChecks["webhook-certificate"] = health.WebhookCertificate(certDir, "tls.crt", "tls.key")
+	}
+	for name, checker := range readyzChecks {
+		if err := mgr.AddReadyzCheck(name, checker); err != nil {
+			setupLog.Error(err, "unable to set up ready check", "check", name)
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -123,3 +314,133 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// --watch-namespacesの値をNamespaceのリストに変換する(空の場合は全Namespaceを対象とするためnilを返す)
+//
+//	・"team-a,team-b"のようなカンマ区切りのリストはそのまま使用する
+//	・"nginx.my.domain/managed=true"のようなLabel Selectorは起動時に一致するNamespaceを取得する
+//	  (起動後に追加されたNamespaceは再起動するまで対象にならない)
+func resolveWatchNamespaces(config *rest.Config, value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	// Namespace名には"=", "!", "(", ")"と空白は含まれないのでLabel Selectorとして扱う
+	if strings.ContainsAny(value, "=!() ") {
+		selector, err := labels.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		c, err := client.New(config, client.Options{Scheme: scheme})
+		if err != nil {
+			return nil, err
+		}
+		var list corev1.NamespaceList
+		if err := c.List(context.Background(), &list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, err
+		}
+		var namespaces []string
+		for _, ns := range list.Items {
+			namespaces = append(namespaces, ns.Name)
+		}
+		if len(namespaces) == 0 {
+			return nil, fmt.Errorf("no namespaces match the selector %q", value)
+		}
+		return namespaces, nil
+	}
+
+	var namespaces []string
+	for _, ns := range strings.Split(value, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" {
+			continue
+		}
+		if msgs := validation.IsDNS1123Label(ns); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid namespace %q: %s", ns, strings.Join(msgs, ", "))
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, nil
+}
+
+// nginx-controller render -f nginx.yaml
+// Nginxのマニフェスト(v1/v2)からControllerが作成する子リソースをYAMLで出力する(クラスタには接続しない)
+// CIでのポリシーチェックやgolden testに使用する
+func runRender(args []string) error {
+	var filename string
+	var namespace string
+	var configFile string
+	var featureGates string
+	var controllerNamespace string
+	namingPolicy := naming.Default()
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.StringVar(&filename, "f", "", "The file that contains the Nginx manifests. Use - for stdin.")
+	fs.StringVar(&namespace, "namespace", "default", "The namespace of the Nginx without metadata.namespace.")
+	fs.StringVar(&configFile, "config", "",
+		"The path to the ControllerConfig file. Its defaultImage and featureGates are applied.")
+	fs.StringVar(&featureGates, "feature-gates", "",
+		"A set of key=value pairs that enable or disable features. Known features: "+strings.Join(features.Known(), ", "))
+	fs.StringVar(&controllerNamespace, "controller-namespace", "nginx-controller-system",
+		"The namespace of the controller, allowed to connect to the reloader sidecars by NetworkPolicies.")
+	fs.StringVar(&namingPolicy.DeploymentTemplate, "deployment-name-template", naming.DefaultDeploymentTemplate,
+		"The template of the Deployment names managed by Nginx.")
+	fs.StringVar(&namingPolicy.ServiceTemplate, "service-name-template", naming.DefaultServiceTemplate,
+		"The template of the Service names managed by Nginx.")
+	fs.StringVar(&namingPolicy.ConfigMapTemplate, "configmap-name-template", naming.DefaultConfigMapTemplate,
+		"The template of the ConfigMap names managed by Nginx.")
+	fs.StringVar(&namingPolicy.ConfigCheckJobTemplate, "config-check-job-name-template", naming.DefaultConfigCheckJobTemplate,
+		"The template of the Job names running nginx -t.")
+	fs.StringVar(&namingPolicy.PodDisruptionBudgetTemplate, "pdb-name-template", naming.DefaultPodDisruptionBudgetTemplate,
+		"The template of the PodDisruptionBudget names managed by Nginx.")
+	fs.StringVar(&namingPolicy.NetworkPolicyTemplate, "networkpolicy-name-template", naming.DefaultNetworkPolicyTemplate,
+		"The template of the NetworkPolicy names managed by Nginx.")
+	fs.StringVar(&namingPolicy.ServiceAccountTemplate, "serviceaccount-name-template", naming.DefaultServiceAccountTemplate,
+		"The template of the ServiceAccount names managed by Nginx.")
+	fs.StringVar(&namingPolicy.RevisionTemplate, "revision-name-template", naming.DefaultRevisionTemplate,
+		"The template of the ControllerRevision names saving the spec of Nginx.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	if filename == "" {
+		return fmt.Errorf("-f is required")
+	}
+	if err := namingPolicy.Validate(); err != nil {
+		return err
+	}
+
+	// Controllerと同じ順に設定ファイル、フラグの順でFeature Gateを適用する
+	if configFile != "" {
+		ctrlConfig, err := controllerconfig.Load(configFile)
+		if err != nil {
+			return err
+		}
+		if err := features.SetFromMap(ctrlConfig.FeatureGates); err != nil {
+			return err
+		}
+		nginxv2.SetBuiltinImage(ctrlConfig.DefaultImage)
+	}
+	if err := features.Set(featureGates); err != nil {
+		return err
+	}
+
+	nginxes, err := render.ReadFile(filename, scheme, namespace)
+	if err != nil {
+		return err
+	}
+
+	r := &controllers.NginxReconciler{Scheme: scheme, Naming: namingPolicy, ControllerNamespace: controllerNamespace}
+	for _, nginx := range nginxes {
+		objs, err := r.Render(nginx)
+		if err != nil {
+			return err
+		}
+		if err := render.WriteYAML(os.Stdout, objs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package controllerconfig はControllerの設定ファイル(ControllerConfig)を読み込み、変更を監視する
+package controllerconfig
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/component-base/featuregate"
+
+	configv1alpha1 "example.com/nginx-controller/api/config/v1alpha1"
+	"example.com/nginx-controller/pkg/features"
+)
+
+var codecs = func() serializer.CodecFactory {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(configv1alpha1.AddToScheme(scheme))
+	// 設定ファイルの誤字に気付けるよう未知のフィールドはエラーにする
+	return serializer.NewCodecFactory(scheme, serializer.EnableStrict)
+}()
+
+// Default はデフォルト値を設定したControllerConfigを返す(--configを指定しない場合に使用する)
+func Default() *configv1alpha1.ControllerConfig {
+	c := &configv1alpha1.ControllerConfig{}
+	c.APIVersion = configv1alpha1.GroupVersion.String()
+	c.Kind = "ControllerConfig"
+	c.Default()
+	return c
+}
+
+// Load はpathの設定ファイルを読み込み、デフォルト値を設定して検証する
+func Load(path string) (*configv1alpha1.ControllerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse は設定ファイルの内容をデコードし、デフォルト値を設定して検証する
+func Parse(data []byte) (*configv1alpha1.ControllerConfig, error) {
+	c := &configv1alpha1.ControllerConfig{}
+	if err := runtime.DecodeInto(codecs.UniversalDecoder(configv1alpha1.GroupVersion), data, c); err != nil {
+		return nil, fmt.Errorf("failed to decode ControllerConfig: %w", err)
+	}
+	c.Default()
+	if err := Validate(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate はControllerConfigの値を確認し、エラーがあればまとめて返す
+func Validate(c *configv1alpha1.ControllerConfig) error {
+	var errs field.ErrorList
+
+	if c.SyncPeriod != nil && c.SyncPeriod.Duration <= 0 {
+		errs = append(errs, field.Invalid(field.NewPath("syncPeriod"), c.SyncPeriod.Duration.String(), "must be greater than 0"))
+	}
+	if c.MaxConcurrentReconciles < 1 {
+		errs = append(errs, field.Invalid(field.NewPath("maxConcurrentReconciles"), c.MaxConcurrentReconciles, "must be at least 1"))
+	}
+	if strings.ContainsAny(c.DefaultImage, " \t\n") {
+		errs = append(errs, field.Invalid(field.NewPath("defaultImage"), c.DefaultImage, "must not contain whitespace"))
+	}
+
+	if len(c.WatchNamespaces) > 0 && c.WatchNamespaceSelector != "" {
+		errs = append(errs, field.Forbidden(field.NewPath("watchNamespaceSelector"), "may not be specified with watchNamespaces"))
+	}
+	for i, ns := range c.WatchNamespaces {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			errs = append(errs, field.Invalid(field.NewPath("watchNamespaces").Index(i), ns, msg))
+		}
+	}
+	if c.WatchNamespaceSelector != "" {
+		if _, err := labels.Parse(c.WatchNamespaceSelector); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("watchNamespaceSelector"), c.WatchNamespaceSelector, err.Error()))
+		}
+	}
+
+	for _, msg := range validation.IsValidPortNum(c.Webhook.Port) {
+		errs = append(errs, field.Invalid(field.NewPath("webhook", "port"), c.Webhook.Port, msg))
+	}
+	if c.Metrics.BindAddress != "0" {
+		if _, _, err := net.SplitHostPort(c.Metrics.BindAddress); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("metrics", "bindAddress"), c.Metrics.BindAddress, err.Error()))
+		}
+	}
+	if _, _, err := net.SplitHostPort(c.Health.HealthProbeBindAddress); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("health", "healthProbeBindAddress"), c.Health.HealthProbeBindAddress, err.Error()))
+	}
+
+	known := features.Gates.GetAll()
+	for name := range c.FeatureGates {
+		if _, ok := known[featuregate.Feature(name)]; !ok {
+			errs = append(errs, field.NotSupported(field.NewPath("featureGates").Key(name), name, features.Known()))
+		}
+	}
+
+	return errs.ToAggregate()
+}
+
+// WatchNamespaces は--watch-namespacesと同じ形式(カンマ区切りのリストまたはLabel Selector)で
+// Watch対象のNamespaceを返す
+func WatchNamespaces(c *configv1alpha1.ControllerConfig) string {
+	if c.WatchNamespaceSelector != "" {
+		return c.WatchNamespaceSelector
+	}
+	return strings.Join(c.WatchNamespaces, ",")
+}
+
+// RestartRequired は再起動しないと反映できない設定のうち、変更されたものの名前を返す
+func RestartRequired(old, next *configv1alpha1.ControllerConfig) []string {
+	var changed []string
+	for _, f := range []struct {
+		name      string
+		old, next interface{}
+	}{
+		{"syncPeriod", old.SyncPeriod, next.SyncPeriod},
+		{"maxConcurrentReconciles", old.MaxConcurrentReconciles, next.MaxConcurrentReconciles},
+		{"watchNamespaces", old.WatchNamespaces, next.WatchNamespaces},
+		{"watchNamespaceSelector", old.WatchNamespaceSelector, next.WatchNamespaceSelector},
+		{"webhook", old.Webhook, next.Webhook},
+		{"metrics", old.Metrics, next.Metrics},
+		{"health", old.Health, next.Health},
+		{"featureGates", old.FeatureGates, next.FeatureGates},
+	} {
+		if !equality.Semantic.DeepEqual(f.old, f.next) {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,112 @@
+package controllerconfig_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	configv1alpha1 "example.com/nginx-controller/api/config/v1alpha1"
+	"example.com/nginx-controller/pkg/controllerconfig"
+)
+
+func TestControllerConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "controllerconfig Suite")
+}
+
+var _ = Describe("ControllerConfig", func() {
+
+	// 設定ファイルが無い場合のデフォルト値が従来のフラグと同じであること
+	It("Should default to the previous flags", func() {
+		c := controllerconfig.Default()
+		Expect(c.SyncPeriod).To(BeNil())
+		Expect(c.MaxConcurrentReconciles).To(Equal(1))
+		Expect(c.DefaultImage).To(Equal("nginx:latest"))
+		Expect(c.Webhook.Port).To(Equal(9443))
+		Expect(c.Metrics.BindAddress).To(Equal(":8080"))
+		Expect(c.Health.HealthProbeBindAddress).To(Equal(":8081"))
+		Expect(controllerconfig.Validate(c)).To(Succeed())
+	})
+
+	// 設定ファイルの値と未指定の項目のデフォルト値を読み込むこと
+	It("Should load a valid config", func() {
+		c, err := controllerconfig.Load("testdata/valid.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.SyncPeriod.Duration).To(Equal(30 * time.Minute))
+		Expect(c.MaxConcurrentReconciles).To(Equal(4))
+		Expect(c.DefaultImage).To(Equal("registry.example.com/nginx:1.23"))
+		Expect(controllerconfig.WatchNamespaces(c)).To(Equal("team-a,team-b"))
+		Expect(c.Webhook.Port).To(Equal(9444))
+		Expect(c.Metrics.BindAddress).To(Equal("0"))
+		Expect(c.Health.HealthProbeBindAddress).To(Equal(":8081"))
+		Expect(c.FeatureGates).To(Equal(map[string]bool{"BlueGreen": false}))
+	})
+
+	// 不正な値はまとめてエラーになること
+	It("Should reject an invalid config", func() {
+		_, err := controllerconfig.Load("testdata/invalid.yaml")
+		Expect(err).To(HaveOccurred())
+		for _, path := range []string{"syncPeriod", "maxConcurrentReconciles", "watchNamespaces[0]", "watchNamespaceSelector", "webhook.port", "health.healthProbeBindAddress", "featureGates[Canary]"} {
+			Expect(err.Error()).To(ContainSubstring(path))
+		}
+	})
+
+	// 未知のフィールドはエラーになること
+	It("Should reject unknown fields", func() {
+		_, err := controllerconfig.Load("testdata/unknown-field.yaml")
+		Expect(err).To(MatchError(ContainSubstring("syncPeriode")))
+	})
+
+	// 再起動が必要な設定の変更のみを返すこと
+	It("Should report the fields requiring a restart", func() {
+		old := controllerconfig.Default()
+		next := old.DeepCopy()
+		next.DefaultImage = "nginx:1.23"
+		Expect(controllerconfig.RestartRequired(old, next)).To(BeEmpty())
+
+		next.Webhook.Port = 9444
+		next.FeatureGates = map[string]bool{"BlueGreen": false}
+		Expect(controllerconfig.RestartRequired(old, next)).To(Equal([]string{"webhook", "featureGates"}))
+	})
+})
+
+var _ = Describe("Watcher", func() {
+
+	// 設定ファイルが変更されたら反映し、不正な設定は無視すること
+	It("Should apply the changed config", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		write := func(image string) {
+			Expect(os.WriteFile(path, []byte("apiVersion: config.nginx.my.domain/v1alpha1\nkind: ControllerConfig\ndefaultImage: "+image+"\n"), 0o644)).To(Succeed())
+		}
+		write("nginx:1.22")
+		current, err := controllerconfig.Load(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		applied := make(chan string, 10)
+		w := controllerconfig.NewWatcher(path, current, func(c *configv1alpha1.ControllerConfig) {
+			applied <- c.DefaultImage
+		}, logr.Discard())
+		w.Interval = 10 * time.Millisecond
+		Expect(w.NeedLeaderElection()).To(BeFalse())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			defer GinkgoRecover()
+			Expect(w.Start(ctx)).To(Succeed())
+		}()
+
+		write("nginx 1.23")
+		Consistently(applied, 100*time.Millisecond).ShouldNot(Receive())
+
+		write("nginx:1.23")
+		Eventually(applied).Should(Receive(Equal("nginx:1.23")))
+	})
+})
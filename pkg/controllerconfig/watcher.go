@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllerconfig
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	configv1alpha1 "example.com/nginx-controller/api/config/v1alpha1"
+)
+
+// DefaultInterval は設定ファイルの変更を確認する間隔
+// ConfigMapをマウントした場合はkubeletが定期的にファイルを更新するため、fsnotifyではなく定期的に読み込む
+const DefaultInterval = 10 * time.Second
+
+// Watcher は設定ファイルの変更を監視し、再起動せずに反映できる設定を反映するRunnable
+type Watcher struct {
+	// 設定ファイルの変更を確認する間隔
+	Interval time.Duration
+
+	path  string
+	apply func(*configv1alpha1.ControllerConfig)
+	log   logr.Logger
+
+	// 起動時の設定(再起動が必要な設定の変更を検出するために変更しない)
+	current *configv1alpha1.ControllerConfig
+	data    []byte
+}
+
+var _ manager.Runnable = &Watcher{}
+var _ manager.LeaderElectionRunnable = &Watcher{}
+
+// NewWatcher はcurrentを読み込み済みの設定としてWatcherを作成する
+// 設定ファイルが変更されるとapplyに変更後の設定を渡す
+func NewWatcher(path string, current *configv1alpha1.ControllerConfig, apply func(*configv1alpha1.ControllerConfig), log logr.Logger) *Watcher {
+	data, _ := os.ReadFile(path)
+	return &Watcher{
+		path:     path,
+		Interval: DefaultInterval,
+		apply:    apply,
+		log:      log,
+		current:  current,
+		data:     data,
+	}
+}
+
+// Start implements manager.Runnable
+func (w *Watcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.reload()
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+// Webhookは全てのレプリカで動作するためLeaderでなくても設定を反映する
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// reload は設定ファイルが変更されていれば読み込んで反映する
+// 不正な設定の場合は変更前の設定のまま動作を続ける
+func (w *Watcher) reload() {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		w.log.Error(err, "unable to read the controller config", "path", w.path)
+		return
+	}
+	if bytes.Equal(data, w.data) {
+		return
+	}
+	w.data = data
+
+	next, err := Parse(data)
+	if err != nil {
+		w.log.Error(err, "ignoring the invalid controller config", "path", w.path)
+		return
+	}
+
+	if changed := RestartRequired(w.current, next); len(changed) > 0 {
+		w.log.Info("the controller config changed but requires a restart to take effect", "path", w.path, "fields", changed)
+	}
+	w.log.Info("reloading the controller config", "path", w.path)
+	w.apply(next)
+}
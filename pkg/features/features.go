@@ -0,0 +1,108 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package features は実験的な機能を有効/無効にするFeature Gateを管理する
+//
+// --feature-gates=BlueGreen=true,... またはControllerConfigのfeatureGatesで指定する
+package features
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/component-base/featuregate"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// spec.strategy.type: BlueGreenによるBlue/Greenデプロイ
+	BlueGreen featuregate.Feature = "BlueGreen"
+
+	// spec.config.reloadStrategy: HotReloadによるPodを再作成しない設定の反映
+	HotReload featuregate.Feature = "HotReload"
+
+	// spec.rollbackToによる過去のspecへのrollback
+	SpecRollback featuregate.Feature = "SpecRollback"
+
+	// spec.disruptionBudgetによるPodDisruptionBudgetの作成
+	DisruptionBudget featuregate.Feature = "DisruptionBudget"
+)
+
+// 各Feature Gateの段階とデフォルト値
+// 新しい機能はAlphaとしてデフォルトで無効にし、使用する場合はクラスタごとに有効にする
+var defaultFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
+	BlueGreen:        {Default: false, PreRelease: featuregate.Alpha},
+	HotReload:        {Default: false, PreRelease: featuregate.Alpha},
+	SpecRollback:     {Default: false, PreRelease: featuregate.Alpha},
+	DisruptionBudget: {Default: false, PreRelease: featuregate.Alpha},
+}
+
+// Gates はController全体で共有するFeature Gate
+var Gates featuregate.MutableFeatureGate = featuregate.NewFeatureGate()
+
+// 各Feature Gateが有効かを公開するmetrics
+var featureEnabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "nginx_controller_feature_enabled",
+	Help: "Whether a feature gate of the nginx controller is enabled (1) or not (0).",
+}, []string{"name", "stage"})
+
+func init() {
+	utilruntime.Must(Gates.Add(defaultFeatureGates))
+	metrics.Registry.MustRegister(featureEnabled)
+	recordMetrics()
+}
+
+// Enabled はFeature Gateが有効かを返す
+func Enabled(f featuregate.Feature) bool {
+	return Gates.Enabled(f)
+}
+
+// Set は"BlueGreen=true,HotReload=false"の形式でFeature Gateを設定する
+func Set(value string) error {
+	if err := Gates.Set(value); err != nil {
+		return err
+	}
+	recordMetrics()
+	return nil
+}
+
+// SetFromMap はFeature Gateを設定する(ControllerConfigのfeatureGatesから設定する)
+func SetFromMap(m map[string]bool) error {
+	if err := Gates.SetFromMap(m); err != nil {
+		return err
+	}
+	recordMetrics()
+	return nil
+}
+
+// Known は指定できるFeature Gateの一覧を返す(フラグのヘルプに表示する)
+func Known() []string {
+	return Gates.KnownFeatures()
+}
+
+func recordMetrics() {
+	// GetAll()にはAllAlpha/AllBetaも含まれるため登録したFeature Gateのみ出力する
+	for f, spec := range defaultFeatureGates {
+		stage := string(spec.PreRelease)
+		if spec.PreRelease == featuregate.GA {
+			stage = "GA"
+		}
+		value := 0.0
+		if Gates.Enabled(f) {
+			value = 1
+		}
+		featureEnabled.WithLabelValues(string(f), stage).Set(value)
+	}
+}
@@ -0,0 +1,65 @@
+package features_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"example.com/nginx-controller/pkg/features"
+)
+
+func TestFeatures(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "features Suite")
+}
+
+var _ = Describe("Feature gates", func() {
+
+	AfterEach(func() {
+		Expect(features.Set("BlueGreen=false,HotReload=false,SpecRollback=false,DisruptionBudget=false")).To(Succeed())
+	})
+
+	// Alphaの機能はデフォルトで無効であること
+	It("Should disable the alpha features by default", func() {
+		Expect(features.Enabled(features.BlueGreen)).To(BeFalse())
+		Expect(features.Enabled(features.HotReload)).To(BeFalse())
+		Expect(features.Enabled(features.SpecRollback)).To(BeFalse())
+		Expect(features.Enabled(features.DisruptionBudget)).To(BeFalse())
+	})
+
+	// フラグの形式とmapの両方で設定できること
+	It("Should set the gates from a flag and a map", func() {
+		Expect(features.Set("BlueGreen=true")).To(Succeed())
+		Expect(features.Enabled(features.BlueGreen)).To(BeTrue())
+
+		Expect(features.SetFromMap(map[string]bool{"HotReload": true})).To(Succeed())
+		Expect(features.Enabled(features.HotReload)).To(BeTrue())
+		Expect(features.Enabled(features.BlueGreen)).To(BeTrue())
+	})
+
+	// 未知のFeature Gateや不正な値はエラーになること
+	It("Should reject unknown gates", func() {
+		Expect(features.Set("Canary=true")).To(MatchError(ContainSubstring("unrecognized feature gate: Canary")))
+		Expect(features.Set("BlueGreen=maybe")).NotTo(Succeed())
+	})
+
+	// 各Feature Gateの状態をmetricsとして公開すること
+	It("Should expose the gates as a metric", func() {
+		Expect(features.Set("BlueGreen=true")).To(Succeed())
+
+		expected := `
+# HELP nginx_controller_feature_enabled Whether a feature gate of the nginx controller is enabled (1) or not (0).
+# TYPE nginx_controller_feature_enabled gauge
+nginx_controller_feature_enabled{name="BlueGreen",stage="ALPHA"} 1
+nginx_controller_feature_enabled{name="DisruptionBudget",stage="ALPHA"} 0
+nginx_controller_feature_enabled{name="HotReload",stage="ALPHA"} 0
+nginx_controller_feature_enabled{name="SpecRollback",stage="ALPHA"} 0
+`
+		Expect(testutil.GatherAndCompare(metrics.Registry, strings.NewReader(expected), "nginx_controller_feature_enabled")).To(Succeed())
+	})
+})
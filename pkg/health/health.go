@@ -0,0 +1,93 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health は/readyzで確認するControllerの状態のChecker
+//
+// 各CheckerはManager.AddReadyzCheckで名前を付けて登録するため、/readyz?verboseで失敗しているCheckerを確認できる
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// 各Checkerが確認を待つ時間の上限
+const checkTimeout = 5 * time.Second
+
+// CacheSynced はManagerのCache(Informer)が同期済みかを確認するChecker
+func CacheSynced(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return fmt.Errorf("informer caches have not been synced yet")
+		}
+		return nil
+	}
+}
+
+// WebhookCertificate はWebhookサーバーの証明書が読み込めて有効期限内かを確認するChecker
+// cert-managerによる更新に追従するため確認のたびにファイルを読み込む
+func WebhookCertificate(certDir, certName, keyName string) healthz.Checker {
+	return func(_ *http.Request) error {
+		return checkCertificate(filepath.Join(certDir, certName), filepath.Join(certDir, keyName), time.Now())
+	}
+}
+
+func checkCertificate(certFile, keyFile string, now time.Time) error {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load the webhook certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("unable to parse the webhook certificate: %w", err)
+	}
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("webhook certificate is not valid until %s", cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("webhook certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// APIServer はAPI Serverに接続できるかを確認するChecker
+// 認証済みのユーザーであれば参照できる/readyzを使用する
+func APIServer(config *rest.Config) (healthz.Checker, error) {
+	config = rest.CopyConfig(config)
+	config.Timeout = checkTimeout
+	client, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request) error {
+		if err := client.RESTClient().Get().AbsPath("/readyz").Do(req.Context()).Error(); err != nil {
+			return fmt.Errorf("API server is not reachable: %w", err)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,96 @@
+package health_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+
+	"example.com/nginx-controller/pkg/health"
+)
+
+func TestHealth(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "health Suite")
+}
+
+var _ = Describe("Readiness checks", func() {
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+
+	// Cacheが同期するまでは失敗すること
+	It("Should report the cache sync", func() {
+		synced := false
+		c := &informertest.FakeInformers{Synced: &synced}
+		Expect(health.CacheSynced(c)(req)).To(MatchError(ContainSubstring("not been synced")))
+
+		synced = true
+		Expect(health.CacheSynced(c)(req)).To(Succeed())
+	})
+
+	// 証明書が無い場合と有効期限外の場合は失敗すること
+	It("Should report the webhook certificate validity", func() {
+		dir := GinkgoT().TempDir()
+		check := health.WebhookCertificate(dir, "tls.crt", "tls.key")
+		Expect(check(req)).To(MatchError(ContainSubstring("unable to load")))
+
+		writeCertificate(dir, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+		Expect(check(req)).To(Succeed())
+
+		writeCertificate(dir, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+		Expect(check(req)).To(MatchError(ContainSubstring("expired")))
+
+		writeCertificate(dir, time.Now().Add(time.Hour), time.Now().Add(2*time.Hour))
+		Expect(check(req)).To(MatchError(ContainSubstring("not valid until")))
+	})
+
+	// API Serverの/readyzが失敗した場合は失敗すること
+	It("Should report the API server reachability", func() {
+		status := http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/readyz"))
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+
+		check, err := health.APIServer(&rest.Config{Host: server.URL})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(check(req)).To(Succeed())
+
+		status = http.StatusServiceUnavailable
+		Expect(check(req)).To(MatchError(ContainSubstring("API server is not reachable")))
+	})
+})
+
+// 有効期間を指定した自己署名証明書をdirに書き込む
+func writeCertificate(dir string, notBefore, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "webhook-service.system.svc"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	Expect(os.WriteFile(filepath.Join(dir, "tls.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "tls.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)).To(Succeed())
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package naming はNginxリソースが管理するリソースの名前を生成する
+package naming
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// テンプレート中でNginxリソース名に置換される文字列
+	NamePlaceholder = "{name}"
+
+	// 生成する名前の最大長(DNS-1035 label)
+	MaxLength = validation.DNS1035LabelMaxLength
+
+	// 最大長を超えた場合に付与するハッシュ値の長さ
+	hashLength = 8
+
+	DefaultMaxNameLength      = 20
+	DefaultDeploymentTemplate = "deploy-" + NamePlaceholder
+	DefaultServiceTemplate    = "service-" + NamePlaceholder
+	DefaultConfigMapTemplate  = "config-" + NamePlaceholder
+
+	DefaultConfigCheckJobTemplate      = "config-check-" + NamePlaceholder
+	DefaultPodDisruptionBudgetTemplate = "pdb-" + NamePlaceholder
+	DefaultNetworkPolicyTemplate       = "netpol-" + NamePlaceholder
+	DefaultServiceAccountTemplate      = "sa-" + NamePlaceholder
+	DefaultRevisionTemplate            = NamePlaceholder
+
+	// Validateでテンプレートから名前を生成する際のNginxリソース名
+	sampleName = "nginx"
+)
+
+// Policy はNginxリソース名の制限と管理するリソースの名前のテンプレート
+// テンプレートが空の場合はデフォルトのテンプレートを使用する
+type Policy struct {
+	// Nginxリソース名の文字数の上限(0の場合はLabelの値として使用できる63文字まで)
+	MaxNameLength int
+
+	DeploymentTemplate string
+	ServiceTemplate    string
+	ConfigMapTemplate  string
+
+	ConfigCheckJobTemplate      string
+	PodDisruptionBudgetTemplate string
+	NetworkPolicyTemplate       string
+	ServiceAccountTemplate      string
+	RevisionTemplate            string
+}
+
+// Default はフラグで変更されない場合のPolicy
+func Default() Policy {
+	return Policy{
+		MaxNameLength:      DefaultMaxNameLength,
+		DeploymentTemplate: DefaultDeploymentTemplate,
+		ServiceTemplate:    DefaultServiceTemplate,
+		ConfigMapTemplate:  DefaultConfigMapTemplate,
+
+		ConfigCheckJobTemplate:      DefaultConfigCheckJobTemplate,
+		PodDisruptionBudgetTemplate: DefaultPodDisruptionBudgetTemplate,
+		NetworkPolicyTemplate:       DefaultNetworkPolicyTemplate,
+		ServiceAccountTemplate:      DefaultServiceAccountTemplate,
+		RevisionTemplate:            DefaultRevisionTemplate,
+	}
+}
+
+// Validate はテンプレートにNamePlaceholderが含まれ、生成する名前がリソースの名前として有効であることを確認する
+func (p Policy) Validate() error {
+	if p.MaxNameLength < 0 {
+		return fmt.Errorf("max name length must not be negative: %d", p.MaxNameLength)
+	}
+	for _, t := range []struct {
+		template string
+		fn       func(string) []string
+	}{
+		{p.DeploymentTemplate, validation.IsDNS1123Subdomain},
+		{p.ServiceTemplate, validation.IsDNS1035Label},
+		{p.ConfigMapTemplate, validation.IsDNS1123Subdomain},
+		// JobのPodには"job-name"のLabelとして付与される
+		{p.ConfigCheckJobTemplate, validation.IsDNS1123Label},
+		{p.PodDisruptionBudgetTemplate, validation.IsDNS1123Subdomain},
+		{p.NetworkPolicyTemplate, validation.IsDNS1123Subdomain},
+		{p.ServiceAccountTemplate, validation.IsDNS1123Subdomain},
+		{p.RevisionTemplate, validation.IsDNS1123Subdomain},
+	} {
+		if t.template == "" {
+			continue
+		}
+		if !strings.Contains(t.template, NamePlaceholder) {
+			return fmt.Errorf("name template %q must contain %s", t.template, NamePlaceholder)
+		}
+		if msgs := t.fn(Generate(t.template, sampleName)); len(msgs) > 0 {
+			return fmt.Errorf("name template %q generates an invalid name: %s", t.template, strings.Join(msgs, "; "))
+		}
+	}
+	return nil
+}
+
+// Deployment はDeploymentの名前を返す(suffixesは"-"で連結する)
+func (p Policy) Deployment(name string, suffixes ...string) string {
+	return Generate(orDefault(p.DeploymentTemplate, DefaultDeploymentTemplate), name, suffixes...)
+}
+
+// Service はServiceの名前を返す(suffixesは"-"で連結する)
+func (p Policy) Service(name string, suffixes ...string) string {
+	return Generate(orDefault(p.ServiceTemplate, DefaultServiceTemplate), name, suffixes...)
+}
+
+// ConfigMap はConfigMapの名前を返す(suffixesは"-"で連結する)
+func (p Policy) ConfigMap(name string, suffixes ...string) string {
+	return Generate(orDefault(p.ConfigMapTemplate, DefaultConfigMapTemplate), name, suffixes...)
+}
+
+// ConfigCheckJob はnginx -tを実行するJobの名前を返す(suffixesは"-"で連結する)
+func (p Policy) ConfigCheckJob(name string, suffixes ...string) string {
+	return Generate(orDefault(p.ConfigCheckJobTemplate, DefaultConfigCheckJobTemplate), name, suffixes...)
+}
+
+// PodDisruptionBudget はPodDisruptionBudgetの名前を返す(suffixesは"-"で連結する)
+func (p Policy) PodDisruptionBudget(name string, suffixes ...string) string {
+	return Generate(orDefault(p.PodDisruptionBudgetTemplate, DefaultPodDisruptionBudgetTemplate), name, suffixes...)
+}
+
+// NetworkPolicy はNetworkPolicyの名前を返す(suffixesは"-"で連結する)
+func (p Policy) NetworkPolicy(name string, suffixes ...string) string {
+	return Generate(orDefault(p.NetworkPolicyTemplate, DefaultNetworkPolicyTemplate), name, suffixes...)
+}
+
+// ServiceAccount はServiceAccountの名前を返す(suffixesは"-"で連結する)
+func (p Policy) ServiceAccount(name string, suffixes ...string) string {
+	return Generate(orDefault(p.ServiceAccountTemplate, DefaultServiceAccountTemplate), name, suffixes...)
+}
+
+// Revision はspecを保存するControllerRevisionの名前を返す(suffixesは"-"で連結する)
+func (p Policy) Revision(name string, suffixes ...string) string {
+	return Generate(orDefault(p.RevisionTemplate, DefaultRevisionTemplate), name, suffixes...)
+}
+
+func orDefault(template, def string) string {
+	if template == "" {
+		return def
+	}
+	return template
+}
+
+// Generate はテンプレートのNamePlaceholderをnameに置換し、suffixesを"-"で連結した名前を返す
+// MaxLengthを超える場合はTruncateで切り詰める
+func Generate(template, name string, suffixes ...string) string {
+	s := strings.ReplaceAll(template, NamePlaceholder, name)
+	for _, suffix := range suffixes {
+		s += "-" + suffix
+	}
+	return Truncate(s)
+}
+
+// Truncate はMaxLengthを超える名前を切り詰め、元の名前のハッシュ値を付与して一意にする
+func Truncate(s string) string {
+	if len(s) <= MaxLength {
+		return s
+	}
+
+	hasher := fnv.New32a()
+	hasher.Write([]byte(s))
+	hash := fmt.Sprintf("%0*x", hashLength, hasher.Sum32())
+
+	prefix := strings.TrimRight(s[:MaxLength-hashLength-1], "-.")
+	return prefix + "-" + hash
+}
@@ -0,0 +1,71 @@
+package naming_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"example.com/nginx-controller/pkg/naming"
+)
+
+func TestNaming(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "naming Suite")
+}
+
+var _ = Describe("Policy", func() {
+
+	// デフォルトのテンプレートで従来と同じ名前を生成すること
+	It("Should generate the default names", func() {
+		p := naming.Default()
+		Expect(p.Deployment("nginx-1")).To(Equal("deploy-nginx-1"))
+		Expect(p.Deployment("nginx-1", "blue")).To(Equal("deploy-nginx-1-blue"))
+		Expect(p.Service("nginx-1", "preview")).To(Equal("service-nginx-1-preview"))
+		Expect(p.ConfigMap("nginx-1")).To(Equal("config-nginx-1"))
+		Expect(p.ConfigCheckJob("nginx-1", "abc")).To(Equal("config-check-nginx-1-abc"))
+		Expect(p.PodDisruptionBudget("nginx-1")).To(Equal("pdb-nginx-1"))
+		Expect(p.NetworkPolicy("nginx-1")).To(Equal("netpol-nginx-1"))
+		Expect(p.ServiceAccount("nginx-1")).To(Equal("sa-nginx-1"))
+		Expect(p.Revision("nginx-1", "abc")).To(Equal("nginx-1-abc"))
+	})
+
+	// テンプレートが未設定の場合はデフォルトのテンプレートを使用すること
+	It("Should fall back to the default templates", func() {
+		Expect(naming.Policy{}.Service("nginx-1")).To(Equal("service-nginx-1"))
+	})
+
+	// 指定したテンプレートで名前を生成すること
+	It("Should generate names from the templates", func() {
+		p := naming.Policy{DeploymentTemplate: "{name}-web"}
+		Expect(p.Deployment("nginx-1", "green")).To(Equal("nginx-1-web-green"))
+	})
+
+	// 長い名前は63文字以内に切り詰めてハッシュ値を付与すること
+	It("Should truncate long names with a hash suffix", func() {
+		long := strings.Repeat("a", 60)
+		blue := naming.Default().Deployment(long, "blue")
+		green := naming.Default().Deployment(long, "green")
+		Expect(len(blue)).To(BeNumerically("<=", naming.MaxLength))
+		Expect(blue).NotTo(Equal(green))
+		Expect(blue).To(Equal(naming.Default().Deployment(long, "blue")))
+	})
+
+	// NamePlaceholderを含まないテンプレートはエラーになること
+	It("Should reject templates without the placeholder", func() {
+		Expect(naming.Policy{ServiceTemplate: "web"}.Validate()).To(HaveOccurred())
+		Expect(naming.Policy{ServiceAccountTemplate: "nginx"}.Validate()).To(HaveOccurred())
+		Expect(naming.Default().Validate()).To(Succeed())
+	})
+
+	// リソースの名前として無効な名前を生成するテンプレートはエラーになること
+	It("Should reject templates generating invalid names", func() {
+		Expect(naming.Policy{DeploymentTemplate: "Deploy-{name}"}.Validate()).To(HaveOccurred())
+		Expect(naming.Policy{ServiceTemplate: "1-{name}"}.Validate()).To(HaveOccurred())
+		Expect(naming.Policy{ConfigCheckJobTemplate: "check.{name}"}.Validate()).To(HaveOccurred())
+		Expect(naming.Policy{RevisionTemplate: "{name}_rev"}.Validate()).To(HaveOccurred())
+		Expect(naming.Policy{RevisionTemplate: "rev-{name}"}.Validate()).To(Succeed())
+	})
+})
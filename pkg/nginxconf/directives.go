@@ -0,0 +1,407 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nginxconf
+
+// Context はディレクティブを記述できるコンテキスト
+type Context int
+
+const (
+	ContextMain Context = 1 << iota
+	ContextEvents
+	ContextHTTP
+	ContextServer
+	ContextLocation
+	ContextUpstream
+	ContextLimitExcept
+	ContextStream
+	ContextMail
+)
+
+// よく使う組み合わせ
+const (
+	ctxHSL = ContextHTTP | ContextServer | ContextLocation
+	ctxHS  = ContextHTTP | ContextServer
+	ctxSL  = ContextServer | ContextLocation
+	ctxAny = ContextMain | ContextEvents | ctxHSL | ContextUpstream | ContextLimitExcept | ContextStream | ContextMail
+)
+
+// String はエラーメッセージに表示するコンテキスト名を返す
+func (c Context) String() string {
+	switch c {
+	case ContextMain:
+		return "main"
+	case ContextEvents:
+		return "events"
+	case ContextHTTP:
+		return "http"
+	case ContextServer:
+		return "server"
+	case ContextLocation:
+		return "location"
+	case ContextUpstream:
+		return "upstream"
+	case ContextLimitExcept:
+		return "limit_except"
+	case ContextStream:
+		return "stream"
+	case ContextMail:
+		return "mail"
+	}
+	return "unknown"
+}
+
+// directiveSpec はディレクティブを記述できるコンテキストとブロックかどうか
+type directiveSpec struct {
+	contexts Context
+	block    bool
+	// ブロックの中のコンテキスト(中身をLintしない場合は0)
+	inner Context
+}
+
+// 公式のnginxイメージに含まれる主要なモジュールのディレクティブ
+var directives = map[string]directiveSpec{
+	// core
+	"include":                 {contexts: ctxAny},
+	"user":                    {contexts: ContextMain},
+	"worker_processes":        {contexts: ContextMain},
+	"worker_rlimit_nofile":    {contexts: ContextMain},
+	"worker_cpu_affinity":     {contexts: ContextMain},
+	"worker_priority":         {contexts: ContextMain},
+	"worker_shutdown_timeout": {contexts: ContextMain},
+	"pid":                     {contexts: ContextMain},
+	"daemon":                  {contexts: ContextMain},
+	"master_process":          {contexts: ContextMain},
+	"load_module":             {contexts: ContextMain},
+	"env":                     {contexts: ContextMain},
+	"pcre_jit":                {contexts: ContextMain},
+	"thread_pool":             {contexts: ContextMain},
+	"timer_resolution":        {contexts: ContextMain},
+	"working_directory":       {contexts: ContextMain},
+	"lock_file":               {contexts: ContextMain},
+	"error_log":               {contexts: ContextMain | ctxHSL | ContextStream | ContextMail},
+	"events":                  {contexts: ContextMain, block: true, inner: ContextEvents},
+	"http":                    {contexts: ContextMain, block: true, inner: ContextHTTP},
+	"stream":                  {contexts: ContextMain, block: true, inner: ContextStream},
+	"mail":                    {contexts: ContextMain, block: true, inner: ContextMail},
+
+	// events
+	"worker_connections":  {contexts: ContextEvents},
+	"use":                 {contexts: ContextEvents},
+	"multi_accept":        {contexts: ContextEvents},
+	"accept_mutex":        {contexts: ContextEvents},
+	"accept_mutex_delay":  {contexts: ContextEvents},
+	"worker_aio_requests": {contexts: ContextEvents},
+
+	// http core
+	"server":                        {contexts: ContextHTTP | ContextStream | ContextMail, block: true, inner: ContextServer},
+	"location":                      {contexts: ctxSL, block: true, inner: ContextLocation},
+	"upstream":                      {contexts: ContextHTTP | ContextStream, block: true, inner: ContextUpstream},
+	"limit_except":                  {contexts: ContextLocation, block: true, inner: ContextLimitExcept},
+	"if":                            {contexts: ctxSL, block: true},
+	"map":                           {contexts: ContextHTTP | ContextStream, block: true},
+	"geo":                           {contexts: ContextHTTP | ContextStream, block: true},
+	"split_clients":                 {contexts: ContextHTTP | ContextStream, block: true},
+	"types":                         {contexts: ctxHSL, block: true},
+	"charset_map":                   {contexts: ContextHTTP, block: true},
+	"listen":                        {contexts: ContextServer},
+	"server_name":                   {contexts: ContextServer | ContextMail},
+	"root":                          {contexts: ctxHSL},
+	"alias":                         {contexts: ContextLocation},
+	"index":                         {contexts: ctxHSL},
+	"try_files":                     {contexts: ctxSL},
+	"internal":                      {contexts: ContextLocation},
+	"default_type":                  {contexts: ctxHSL},
+	"sendfile":                      {contexts: ctxHSL},
+	"sendfile_max_chunk":            {contexts: ctxHSL},
+	"tcp_nopush":                    {contexts: ctxHSL},
+	"tcp_nodelay":                   {contexts: ctxHSL | ContextStream},
+	"keepalive_timeout":             {contexts: ctxHSL | ContextUpstream},
+	"keepalive_requests":            {contexts: ctxHSL | ContextUpstream},
+	"keepalive_time":                {contexts: ctxHSL | ContextUpstream},
+	"keepalive_disable":             {contexts: ctxHSL},
+	"client_max_body_size":          {contexts: ctxHSL},
+	"client_body_buffer_size":       {contexts: ctxHSL},
+	"client_body_timeout":           {contexts: ctxHSL},
+	"client_body_temp_path":         {contexts: ctxHSL},
+	"client_body_in_file_only":      {contexts: ctxHSL},
+	"client_header_timeout":         {contexts: ctxHS},
+	"client_header_buffer_size":     {contexts: ctxHS},
+	"large_client_header_buffers":   {contexts: ctxHS},
+	"server_tokens":                 {contexts: ctxHSL},
+	"server_names_hash_max_size":    {contexts: ContextHTTP},
+	"server_names_hash_bucket_size": {contexts: ContextHTTP},
+	"types_hash_max_size":           {contexts: ctxHSL},
+	"types_hash_bucket_size":        {contexts: ctxHSL},
+	"variables_hash_max_size":       {contexts: ContextHTTP | ContextStream},
+	"variables_hash_bucket_size":    {contexts: ContextHTTP | ContextStream},
+	"map_hash_max_size":             {contexts: ContextHTTP | ContextStream},
+	"map_hash_bucket_size":          {contexts: ContextHTTP | ContextStream},
+	"resolver":                      {contexts: ctxHSL | ContextUpstream | ContextStream | ContextMail},
+	"resolver_timeout":              {contexts: ctxHSL | ContextStream | ContextMail},
+	"send_timeout":                  {contexts: ctxHSL},
+	"reset_timedout_connection":     {contexts: ctxHSL},
+	"absolute_redirect":             {contexts: ctxHSL},
+	"port_in_redirect":              {contexts: ctxHSL},
+	"server_name_in_redirect":       {contexts: ctxHSL},
+	"merge_slashes":                 {contexts: ctxHS},
+	"underscores_in_headers":        {contexts: ctxHS},
+	"ignore_invalid_headers":        {contexts: ctxHS},
+	"chunked_transfer_encoding":     {contexts: ctxHSL},
+	"etag":                          {contexts: ctxHSL},
+	"if_modified_since":             {contexts: ctxHSL},
+	"log_not_found":                 {contexts: ctxHSL},
+	"log_subrequest":                {contexts: ctxHSL},
+	"open_file_cache":               {contexts: ctxHSL},
+	"open_file_cache_valid":         {contexts: ctxHSL},
+	"open_file_cache_min_uses":      {contexts: ctxHSL},
+	"open_file_cache_errors":        {contexts: ctxHSL},
+	"output_buffers":                {contexts: ctxHSL},
+	"postpone_output":               {contexts: ctxHSL},
+	"recursive_error_pages":         {contexts: ctxHSL},
+	"error_page":                    {contexts: ctxHSL},
+	"satisfy":                       {contexts: ctxHSL},
+	"lingering_close":               {contexts: ctxHSL},
+	"lingering_time":                {contexts: ctxHSL},
+	"lingering_timeout":             {contexts: ctxHSL},
+	"msie_padding":                  {contexts: ctxHSL},
+	"msie_refresh":                  {contexts: ctxHSL},
+	"aio":                           {contexts: ctxHSL},
+	"directio":                      {contexts: ctxHSL},
+	"read_ahead":                    {contexts: ctxHSL},
+	"disable_symlinks":              {contexts: ctxHSL},
+	"max_ranges":                    {contexts: ctxHSL},
+	"limit_rate":                    {contexts: ctxHSL},
+	"limit_rate_after":              {contexts: ctxHSL},
+	"subrequest_output_buffer_size": {contexts: ctxHSL},
+	"http2":                         {contexts: ctxHS},
+	"http2_max_concurrent_streams":  {contexts: ctxHS},
+	"http2_chunk_size":              {contexts: ctxHSL},
+	"charset":                       {contexts: ctxHSL},
+	"source_charset":                {contexts: ctxHSL},
+	"override_charset":              {contexts: ctxHSL},
+	"charset_types":                 {contexts: ctxHSL},
+
+	// rewrite
+	"return":                      {contexts: ctxSL},
+	"rewrite":                     {contexts: ctxSL},
+	"set":                         {contexts: ctxSL},
+	"break":                       {contexts: ctxSL},
+	"rewrite_log":                 {contexts: ctxHSL},
+	"uninitialized_variable_warn": {contexts: ctxHSL},
+
+	// log
+	"access_log":          {contexts: ctxHSL | ContextStream},
+	"log_format":          {contexts: ContextHTTP | ContextStream},
+	"open_log_file_cache": {contexts: ctxHSL | ContextStream},
+
+	// headers
+	"add_header":  {contexts: ctxHSL},
+	"add_trailer": {contexts: ctxHSL},
+	"expires":     {contexts: ctxHSL},
+
+	// access/auth
+	"allow":                {contexts: ctxHSL | ContextLimitExcept | ContextStream},
+	"deny":                 {contexts: ctxHSL | ContextLimitExcept | ContextStream},
+	"auth_basic":           {contexts: ctxHSL | ContextLimitExcept},
+	"auth_basic_user_file": {contexts: ctxHSL | ContextLimitExcept},
+	"auth_request":         {contexts: ctxHSL},
+	"auth_request_set":     {contexts: ctxHSL},
+
+	// limit
+	"limit_req_zone":       {contexts: ContextHTTP},
+	"limit_req":            {contexts: ctxHSL},
+	"limit_req_status":     {contexts: ctxHSL},
+	"limit_req_log_level":  {contexts: ctxHSL},
+	"limit_req_dry_run":    {contexts: ctxHSL},
+	"limit_conn_zone":      {contexts: ContextHTTP | ContextStream},
+	"limit_conn":           {contexts: ctxHSL | ContextStream},
+	"limit_conn_status":    {contexts: ctxHSL},
+	"limit_conn_log_level": {contexts: ctxHSL | ContextStream},
+
+	// real_ip
+	"set_real_ip_from":  {contexts: ctxHSL | ContextStream},
+	"real_ip_header":    {contexts: ctxHSL},
+	"real_ip_recursive": {contexts: ctxHSL},
+
+	// gzip
+	"gzip":              {contexts: ctxHSL},
+	"gzip_types":        {contexts: ctxHSL},
+	"gzip_comp_level":   {contexts: ctxHSL},
+	"gzip_min_length":   {contexts: ctxHSL},
+	"gzip_proxied":      {contexts: ctxHSL},
+	"gzip_vary":         {contexts: ctxHSL},
+	"gzip_disable":      {contexts: ctxHSL},
+	"gzip_http_version": {contexts: ctxHSL},
+	"gzip_buffers":      {contexts: ctxHSL},
+	"gzip_static":       {contexts: ctxHSL},
+
+	// ssl
+	"ssl_certificate":           {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_certificate_key":       {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_protocols":             {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_ciphers":               {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_prefer_server_ciphers": {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_session_cache":         {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_session_timeout":       {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_session_tickets":       {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_session_ticket_key":    {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_dhparam":               {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_ecdh_curve":            {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_stapling":              {contexts: ctxHS},
+	"ssl_stapling_verify":       {contexts: ctxHS},
+	"ssl_trusted_certificate":   {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_client_certificate":    {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_verify_client":         {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_verify_depth":          {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_password_file":         {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_buffer_size":           {contexts: ctxHS},
+	"ssl_early_data":            {contexts: ctxHS},
+	"ssl_crl":                   {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_conf_command":          {contexts: ctxHS | ContextStream | ContextMail},
+	"ssl_reject_handshake":      {contexts: ctxHS},
+
+	// proxy
+	"proxy_pass":                     {contexts: ContextLocation | ContextLimitExcept | ContextStream},
+	"proxy_set_header":               {contexts: ctxHSL},
+	"proxy_hide_header":              {contexts: ctxHSL},
+	"proxy_pass_header":              {contexts: ctxHSL},
+	"proxy_redirect":                 {contexts: ctxHSL},
+	"proxy_buffering":                {contexts: ctxHSL},
+	"proxy_buffer_size":              {contexts: ctxHSL | ContextStream},
+	"proxy_buffers":                  {contexts: ctxHSL},
+	"proxy_busy_buffers_size":        {contexts: ctxHSL},
+	"proxy_connect_timeout":          {contexts: ctxHSL | ContextStream},
+	"proxy_read_timeout":             {contexts: ctxHSL},
+	"proxy_send_timeout":             {contexts: ctxHSL},
+	"proxy_timeout":                  {contexts: ContextStream | ContextServer},
+	"proxy_http_version":             {contexts: ctxHSL},
+	"proxy_cache":                    {contexts: ctxHSL},
+	"proxy_cache_path":               {contexts: ContextHTTP},
+	"proxy_cache_key":                {contexts: ctxHSL},
+	"proxy_cache_valid":              {contexts: ctxHSL},
+	"proxy_cache_bypass":             {contexts: ctxHSL},
+	"proxy_no_cache":                 {contexts: ctxHSL},
+	"proxy_cache_use_stale":          {contexts: ctxHSL},
+	"proxy_cache_lock":               {contexts: ctxHSL},
+	"proxy_cache_methods":            {contexts: ctxHSL},
+	"proxy_cache_min_uses":           {contexts: ctxHSL},
+	"proxy_cache_revalidate":         {contexts: ctxHSL},
+	"proxy_cache_background_update":  {contexts: ctxHSL},
+	"proxy_intercept_errors":         {contexts: ctxHSL},
+	"proxy_next_upstream":            {contexts: ctxHSL | ContextStream},
+	"proxy_next_upstream_tries":      {contexts: ctxHSL | ContextStream},
+	"proxy_next_upstream_timeout":    {contexts: ctxHSL | ContextStream},
+	"proxy_ignore_headers":           {contexts: ctxHSL},
+	"proxy_ignore_client_abort":      {contexts: ctxHSL},
+	"proxy_max_temp_file_size":       {contexts: ctxHSL},
+	"proxy_temp_path":                {contexts: ctxHSL},
+	"proxy_request_buffering":        {contexts: ctxHSL},
+	"proxy_ssl_server_name":          {contexts: ctxHSL | ContextStream},
+	"proxy_ssl_verify":               {contexts: ctxHSL | ContextStream},
+	"proxy_ssl_trusted_certificate":  {contexts: ctxHSL | ContextStream},
+	"proxy_ssl_name":                 {contexts: ctxHSL | ContextStream},
+	"proxy_ssl_protocols":            {contexts: ctxHSL | ContextStream},
+	"proxy_ssl_certificate":          {contexts: ctxHSL | ContextStream},
+	"proxy_ssl_certificate_key":      {contexts: ctxHSL | ContextStream},
+	"proxy_ssl_session_reuse":        {contexts: ctxHSL | ContextStream},
+	"proxy_cookie_path":              {contexts: ctxHSL},
+	"proxy_cookie_domain":            {contexts: ctxHSL},
+	"proxy_pass_request_headers":     {contexts: ctxHSL},
+	"proxy_pass_request_body":        {contexts: ctxHSL},
+	"proxy_set_body":                 {contexts: ctxHSL},
+	"proxy_method":                   {contexts: ctxHSL},
+	"proxy_bind":                     {contexts: ctxHSL | ContextStream},
+	"proxy_socket_keepalive":         {contexts: ctxHSL | ContextStream},
+	"proxy_headers_hash_max_size":    {contexts: ctxHSL},
+	"proxy_headers_hash_bucket_size": {contexts: ctxHSL},
+	"proxy_store":                    {contexts: ctxHSL},
+	"proxy_limit_rate":               {contexts: ctxHSL},
+	"proxy_force_ranges":             {contexts: ctxHSL},
+
+	// fastcgi
+	"fastcgi_pass":             {contexts: ContextLocation},
+	"fastcgi_param":            {contexts: ctxHSL},
+	"fastcgi_index":            {contexts: ctxHSL},
+	"fastcgi_split_path_info":  {contexts: ContextLocation},
+	"fastcgi_read_timeout":     {contexts: ctxHSL},
+	"fastcgi_send_timeout":     {contexts: ctxHSL},
+	"fastcgi_connect_timeout":  {contexts: ctxHSL},
+	"fastcgi_buffers":          {contexts: ctxHSL},
+	"fastcgi_buffer_size":      {contexts: ctxHSL},
+	"fastcgi_buffering":        {contexts: ctxHSL},
+	"fastcgi_intercept_errors": {contexts: ctxHSL},
+	"fastcgi_cache":            {contexts: ctxHSL},
+	"fastcgi_cache_key":        {contexts: ctxHSL},
+	"fastcgi_cache_valid":      {contexts: ctxHSL},
+	"fastcgi_cache_path":       {contexts: ContextHTTP},
+	"fastcgi_hide_header":      {contexts: ctxHSL},
+	"fastcgi_pass_header":      {contexts: ctxHSL},
+	"fastcgi_keep_conn":        {contexts: ctxHSL},
+
+	// upstream
+	"keepalive":  {contexts: ContextUpstream},
+	"least_conn": {contexts: ContextUpstream},
+	"ip_hash":    {contexts: ContextUpstream},
+	"hash":       {contexts: ContextUpstream},
+	"random":     {contexts: ContextUpstream},
+	"zone":       {contexts: ContextUpstream},
+
+	// その他の公式モジュール
+	"autoindex":                {contexts: ctxHSL},
+	"autoindex_exact_size":     {contexts: ctxHSL},
+	"autoindex_format":         {contexts: ctxHSL},
+	"autoindex_localtime":      {contexts: ctxHSL},
+	"stub_status":              {contexts: ctxSL},
+	"sub_filter":               {contexts: ctxHSL},
+	"sub_filter_once":          {contexts: ctxHSL},
+	"sub_filter_types":         {contexts: ctxHSL},
+	"sub_filter_last_modified": {contexts: ctxHSL},
+	"mirror":                   {contexts: ctxHSL},
+	"mirror_request_body":      {contexts: ctxHSL},
+	"ssi":                      {contexts: ctxHSL},
+	"ssi_types":                {contexts: ctxHSL},
+	"valid_referers":           {contexts: ctxSL},
+	"secure_link":              {contexts: ctxHSL},
+	"secure_link_md5":          {contexts: ctxHSL},
+	"secure_link_secret":       {contexts: ContextLocation},
+	"empty_gif":                {contexts: ContextLocation},
+	"dav_methods":              {contexts: ctxHSL},
+	"create_full_put_path":     {contexts: ctxHSL},
+	"userid":                   {contexts: ctxHSL},
+	"grpc_pass":                {contexts: ContextLocation},
+	"grpc_set_header":          {contexts: ctxHSL},
+	"grpc_read_timeout":        {contexts: ctxHSL},
+	"grpc_send_timeout":        {contexts: ctxHSL},
+	"grpc_connect_timeout":     {contexts: ctxHSL},
+	"uwsgi_pass":               {contexts: ContextLocation},
+	"uwsgi_param":              {contexts: ctxHSL},
+	"scgi_pass":                {contexts: ContextLocation},
+	"scgi_param":               {contexts: ctxHSL},
+	"memcached_pass":           {contexts: ContextLocation},
+}
+
+// upstreamブロックの中の"server"はブロックではなくサーバーのアドレスを指定するディレクティブ
+var upstreamServer = directiveSpec{contexts: ContextUpstream}
+
+// lookup はコンテキストに応じたディレクティブの定義を返す
+func lookup(name string, ctx Context) (directiveSpec, bool) {
+	if name == "server" && ctx == ContextUpstream {
+		return upstreamServer, true
+	}
+	spec, ok := directives[name]
+	return spec, ok
+}
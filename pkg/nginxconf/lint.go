@@ -0,0 +1,124 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nginxconf
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Issue はLintで検出した問題と行番号
+type Issue struct {
+	Line    int
+	Message string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+// Lint はnginxの設定をパースし、以下の問題を検出する
+//
+//	・構文エラー(";"や"}"の不足など)
+//	・存在しないディレクティブ
+//	・記述できないコンテキストに書かれたディレクティブ
+//	・";"の付け忘れにより次の行のディレクティブが引数になっているもの
+//	・同じserverブロック内で重複しているlisten
+//
+// ctxには設定がincludeされるコンテキストを指定する(conf.d配下の場合はContextHTTP)
+func Lint(src string, ctx Context) []Issue {
+	config, err := Parse(src)
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			return []Issue{{Line: parseErr.Line, Message: parseErr.Msg}}
+		}
+		return []Issue{{Line: 1, Message: err.Error()}}
+	}
+
+	var issues []Issue
+	lintBlock(config.Directives, ctx, &issues)
+	return issues
+}
+
+// lintBlock はブロック内のディレクティブを再帰的に確認する
+func lintBlock(block []*Directive, ctx Context, issues *[]Issue) {
+	listens := make(map[string]int)
+
+	for _, d := range block {
+		spec, ok := lookup(d.Name, ctx)
+		if !ok {
+			*issues = append(*issues, Issue{Line: d.Line, Message: fmt.Sprintf("unknown directive %q", d.Name)})
+			continue
+		}
+		if spec.contexts&ctx == 0 {
+			*issues = append(*issues, Issue{Line: d.Line, Message: fmt.Sprintf("%q directive is not allowed in %s context", d.Name, ctx)})
+		}
+
+		// ";"を付け忘れると次の行のディレクティブが引数として扱われる
+		if line, name, ok := missingSemicolon(d, ctx); ok {
+			*issues = append(*issues, Issue{Line: d.Line, Message: fmt.Sprintf("directive %q is not terminated by \";\" before %q on line %d", d.Name, name, line)})
+		}
+
+		switch {
+		case spec.block && !d.IsBlock:
+			*issues = append(*issues, Issue{Line: d.Line, Message: fmt.Sprintf("directive %q has no opening \"{\"", d.Name)})
+		case !spec.block && d.IsBlock:
+			*issues = append(*issues, Issue{Line: d.Line, Message: fmt.Sprintf("directive %q does not take a block", d.Name)})
+		case d.IsBlock && d.Name == "if":
+			// ifブロックの中は外側のコンテキストと同じディレクティブを記述できる
+			lintBlock(d.Block, ctx, issues)
+		case d.IsBlock && spec.inner != 0:
+			lintBlock(d.Block, spec.inner, issues)
+		}
+
+		if d.Name == "listen" && ctx == ContextServer && len(d.Args) > 0 {
+			address := normalizeListen(d.Args[0])
+			if first, ok := listens[address]; ok {
+				*issues = append(*issues, Issue{Line: d.Line, Message: fmt.Sprintf("duplicate listen %q, already defined on line %d", d.Args[0], first)})
+			} else {
+				listens[address] = d.Line
+			}
+		}
+	}
+}
+
+// missingSemicolon はディレクティブより後の行の先頭にある引数が既知のディレクティブ名であれば
+// その行番号と名前を返す
+func missingSemicolon(d *Directive, ctx Context) (int, string, bool) {
+	prev := d.Line
+	for i, arg := range d.Args {
+		if d.ArgLines[i] > prev {
+			if _, ok := lookup(arg, ctx); ok {
+				return d.ArgLines[i], arg, true
+			}
+		}
+		prev = d.ArgLines[i]
+	}
+	return 0, "", false
+}
+
+var portOnly = regexp.MustCompile(`^[0-9]+$`)
+
+// normalizeListen はlistenのアドレスを比較できる形式にする("80"と"*:80"は同じ)
+func normalizeListen(address string) string {
+	if portOnly.MatchString(address) {
+		return "*:" + address
+	}
+	return address
+}
@@ -0,0 +1,83 @@
+package nginxconf_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"example.com/nginx-controller/pkg/nginxconf"
+)
+
+func TestNginxconf(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "nginxconf Suite")
+}
+
+var _ = Describe("Lint", func() {
+
+	// 正しい設定の場合は何も検出しないこと
+	It("Should accept a valid server block", func() {
+		src := `
+server {
+    listen 80;
+    listen [::]:80;
+    server_name example.com "www.example.com";
+
+    location / {
+        root /usr/share/nginx/html;
+        index index.html;
+        if ($request_method = POST) {
+            return 405;
+        }
+    }
+
+    location ~ "^/api/v[0-9]{1,2}/" {
+        proxy_pass http://backend;
+        proxy_set_header Host $host;
+        proxy_set_header X-Forwarded-For ${proxy_add_x_forwarded_for};
+    }
+}
+
+upstream backend {
+    server 10.0.0.1:8080 weight=2;
+    keepalive 16;
+}
+
+map $http_upgrade $connection_upgrade {
+    default upgrade;
+    ''      close;
+}
+`
+		Expect(nginxconf.Lint(src, nginxconf.ContextHTTP)).To(BeEmpty())
+	})
+
+	It("Should report unknown directives", func() {
+		src := "server {\n    lisen 80;\n}\n"
+		Expect(nginxconf.Lint(src, nginxconf.ContextHTTP)).To(Equal([]nginxconf.Issue{{Line: 2, Message: `unknown directive "lisen"`}}))
+	})
+
+	It("Should report directives in the wrong context", func() {
+		src := "server {\n    listen 80;\n}\nlisten 8080;\n"
+		Expect(nginxconf.Lint(src, nginxconf.ContextHTTP)).To(Equal([]nginxconf.Issue{{Line: 4, Message: `"listen" directive is not allowed in http context`}}))
+	})
+
+	It("Should report missing semicolons", func() {
+		src := "server {\n    listen 80\n    server_name example.com;\n}\n"
+		Expect(nginxconf.Lint(src, nginxconf.ContextHTTP)).To(Equal([]nginxconf.Issue{{Line: 2, Message: `directive "listen" is not terminated by ";" before "server_name" on line 3`}}))
+
+		src = "server {\n    listen 80;\n    root /var/www\n}\n"
+		Expect(nginxconf.Lint(src, nginxconf.ContextHTTP)).To(Equal([]nginxconf.Issue{{Line: 3, Message: `directive "root" is not terminated by ";"`}}))
+	})
+
+	It("Should report duplicate listen in the same server", func() {
+		src := "server {\n    listen 80;\n    listen *:80;\n}\nserver {\n    listen 80;\n}\n"
+		Expect(nginxconf.Lint(src, nginxconf.ContextHTTP)).To(Equal([]nginxconf.Issue{{Line: 3, Message: `duplicate listen "*:80", already defined on line 2`}}))
+	})
+
+	It("Should report unbalanced braces", func() {
+		Expect(nginxconf.Lint("server {\n    listen 80;\n", nginxconf.ContextHTTP)).To(Equal([]nginxconf.Issue{{Line: 2, Message: `unexpected end of file, expecting "}"`}}))
+		Expect(nginxconf.Lint("server {\n}\n}\n", nginxconf.ContextHTTP)).To(Equal([]nginxconf.Issue{{Line: 3, Message: `unexpected "}"`}}))
+	})
+})
@@ -0,0 +1,217 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nginxconf はnginxの設定ファイルをパースしてLintするパッケージ
+package nginxconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Directive はnginxの設定の1つのディレクティブ
+type Directive struct {
+	Name string
+	Args []string
+
+	// ディレクティブ名が記述されている行番号(1始まり)
+	Line int
+
+	// 引数ごとの行番号(Argsと同じ長さ)
+	ArgLines []int
+
+	// ブロックディレクティブの場合はtrue
+	IsBlock bool
+	Block   []*Directive
+}
+
+// Config はパースしたnginxの設定
+type Config struct {
+	Directives []*Directive
+}
+
+// ParseError は構文エラーと発生した行番号
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// token は字句解析の結果
+type token struct {
+	value  string
+	line   int
+	quoted bool
+}
+
+// Parse はnginxの設定をパースする
+// includeディレクティブはファイルを読み込まずにディレクティブとして扱う
+func Parse(src string) (*Config, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	directives, err := p.parseBlock(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{Directives: directives}, nil
+}
+
+// tokenize は設定を単語、クォートされた文字列、"{" "}" ";"に分割する
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	var current strings.Builder
+	line := 1
+	start := 0
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, token{value: current.String(), line: start})
+			current.Reset()
+		}
+	}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '\n':
+			flush()
+			line++
+		case c == ' ' || c == '\t' || c == '\r':
+			flush()
+		case c == '#' && current.Len() == 0:
+			// 行末までコメント
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '$' && i+1 < len(src) && src[i+1] == '{':
+			// "${var}"の"{" "}"はブロックではなく単語の一部として扱う
+			if current.Len() == 0 {
+				start = line
+			}
+			end := strings.IndexByte(src[i:], '}')
+			if end < 0 {
+				return nil, &ParseError{Line: line, Msg: "unexpected end of file, unterminated variable"}
+			}
+			current.WriteString(src[i : i+end+1])
+			i += end
+		case c == '{' || c == '}' || c == ';':
+			flush()
+			tokens = append(tokens, token{value: string(c), line: line})
+		case (c == '"' || c == '\'') && current.Len() == 0:
+			quote := c
+			startLine := line
+			var value strings.Builder
+			i++
+			for ; i < len(src) && src[i] != quote; i++ {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				if src[i] == '\n' {
+					line++
+				}
+				value.WriteByte(src[i])
+			}
+			if i >= len(src) {
+				return nil, &ParseError{Line: startLine, Msg: "unexpected end of file, unterminated quoted string"}
+			}
+			tokens = append(tokens, token{value: value.String(), line: startLine, quoted: true})
+		default:
+			if current.Len() == 0 {
+				start = line
+			}
+			if c == '\\' && i+1 < len(src) {
+				current.WriteByte(c)
+				i++
+				c = src[i]
+			}
+			current.WriteByte(c)
+		}
+	}
+	flush()
+
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parseBlock はブロックの中身("}"まで、またはファイルの終わりまで)をパースする
+func (p *parser) parseBlock(inBlock bool) ([]*Directive, error) {
+	var directives []*Directive
+
+	for p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		p.pos++
+
+		if !t.quoted {
+			switch t.value {
+			case "}":
+				if !inBlock {
+					return nil, &ParseError{Line: t.line, Msg: `unexpected "}"`}
+				}
+				return directives, nil
+			case "{", ";":
+				return nil, &ParseError{Line: t.line, Msg: fmt.Sprintf("unexpected %q", t.value)}
+			}
+		}
+
+		d := &Directive{Name: t.value, Line: t.line}
+		for {
+			if p.pos >= len(p.tokens) {
+				return nil, &ParseError{Line: d.Line, Msg: fmt.Sprintf("unexpected end of file, directive %q is not terminated by \";\"", d.Name)}
+			}
+			arg := p.tokens[p.pos]
+			p.pos++
+
+			if !arg.quoted && arg.value == ";" {
+				break
+			}
+			if !arg.quoted && arg.value == "}" {
+				return nil, &ParseError{Line: d.Line, Msg: fmt.Sprintf("directive %q is not terminated by \";\"", d.Name)}
+			}
+			if !arg.quoted && arg.value == "{" {
+				block, err := p.parseBlock(true)
+				if err != nil {
+					return nil, err
+				}
+				d.IsBlock = true
+				d.Block = block
+				break
+			}
+			d.Args = append(d.Args, arg.value)
+			d.ArgLines = append(d.ArgLines, arg.line)
+		}
+
+		directives = append(directives, d)
+	}
+
+	if inBlock {
+		return nil, &ParseError{Line: p.tokens[len(p.tokens)-1].line, Msg: `unexpected end of file, expecting "}"`}
+	}
+
+	return directives, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package render はNginxのマニフェストを読み込み、Controllerが生成する子リソースをYAMLとして出力する
+// nginx-controller renderとkubectl nginx render/diffで使用する
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	nginxv1 "example.com/nginx-controller/api/v1"
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// ReadFile はファイル(-の場合は標準入力)からNginxを読み込む
+func ReadFile(filename string, scheme *runtime.Scheme, namespace string) ([]*nginxv2.Nginx, error) {
+	if filename == "-" {
+		return Decode(os.Stdin, scheme, namespace)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nginxes, err := Decode(f, scheme, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return nginxes, nil
+}
+
+// Decode は"---"で区切られたYAMLからNginxを読み込む
+// v1のNginxはHubのv2に変換し、Webhookの代わりに組み込みのデフォルト値を設定する
+// metadata.namespaceが指定されていない場合はnamespaceを設定する
+func Decode(in io.Reader, scheme *runtime.Scheme, namespace string) ([]*nginxv2.Nginx, error) {
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(in))
+
+	var nginxes []*nginxv2.Nginx
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		obj, gvk, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var nginx *nginxv2.Nginx
+		switch obj := obj.(type) {
+		case *nginxv2.Nginx:
+			nginx = obj
+		case *nginxv1.Nginx:
+			nginx = &nginxv2.Nginx{}
+			if err := obj.ConvertTo(nginx); err != nil {
+				return nil, fmt.Errorf("unable to convert Nginx %s to v2: %w", obj.Name, err)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported kind %s, only Nginx is supported", gvk)
+		}
+
+		if nginx.Namespace == "" {
+			nginx.Namespace = namespace
+		}
+		nginx.ApplyBuiltinDefaults()
+		nginxes = append(nginxes, nginx)
+	}
+
+	if len(nginxes) == 0 {
+		return nil, fmt.Errorf("no Nginx found")
+	}
+	return nginxes, nil
+}
+
+// WriteYAML はオブジェクトを"---"で区切ったYAMLとして出力する
+// 差分の確認に不要なmanagedFieldsは出力しない
+func WriteYAML(w io.Writer, objs []client.Object) error {
+	for _, obj := range objs {
+		obj.SetManagedFields(nil)
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
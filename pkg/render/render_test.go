@@ -0,0 +1,195 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+
+	nginxv1 "example.com/nginx-controller/api/v1"
+	nginxv2 "example.com/nginx-controller/api/v2"
+	"example.com/nginx-controller/controllers"
+	"example.com/nginx-controller/pkg/features"
+	"example.com/nginx-controller/pkg/naming"
+	"example.com/nginx-controller/pkg/render"
+)
+
+func TestRender(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "render Suite")
+}
+
+var _ = BeforeSuite(func() {
+	// Alphaの機能も含めてテストするため全てのFeature Gateを有効にする
+	Expect(features.Set("BlueGreen=true,HotReload=true,SpecRollback=true,DisruptionBudget=true")).To(Succeed())
+})
+
+// UPDATE_GOLDEN=trueの場合はgoldenファイルを現在の出力で更新する
+var updateGolden = os.Getenv("UPDATE_GOLDEN") == "true"
+
+// 子リソースを生成するReconciler(Controllerのデフォルトの設定と同じ)
+func newReconciler(scheme *runtime.Scheme) *controllers.NginxReconciler {
+	return &controllers.NginxReconciler{Scheme: scheme, Naming: naming.Default(), ControllerNamespace: "nginx-controller-system"}
+}
+
+func newScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(nginxv1.AddToScheme(scheme))
+	utilruntime.Must(nginxv2.AddToScheme(scheme))
+	return scheme
+}
+
+var _ = Describe("Render", func() {
+
+	scheme := newScheme()
+
+	// testdata/<name>.yamlから生成した子リソースがtestdata/<name>.golden.yamlと一致すること
+	DescribeTable("Should render the child resources same as the golden file",
+		func(name string) {
+			nginxes, err := render.ReadFile(filepath.Join("testdata", name+".yaml"), scheme, "default")
+			Expect(err).NotTo(HaveOccurred())
+
+			r := newReconciler(scheme)
+			var out bytes.Buffer
+			for _, nginx := range nginxes {
+				objs, err := r.Render(nginx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(render.WriteYAML(&out, objs)).To(Succeed())
+			}
+
+			golden := filepath.Join("testdata", name+".golden.yaml")
+			if updateGolden {
+				Expect(os.WriteFile(golden, out.Bytes(), 0o644)).To(Succeed())
+			}
+			expected, err := os.ReadFile(golden)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.String()).To(Equal(string(expected)))
+		},
+		Entry("v1 Nginx with RollingUpdate", "basic"),
+		Entry("BlueGreen with the preview Service", "bluegreen"),
+		Entry("HotReload config, LoadBalancer and PodDisruptionBudget", "config"),
+		Entry("NetworkPolicy without the upstream Service", "networkpolicy"),
+		Entry("Hardened security context with HotReload", "hardened"),
+		Entry("ServiceAccount with workload identity annotations", "serviceaccount"),
+		Entry("sidecar, init container and volumes", "podtemplate"),
+		Entry("env and envFrom without the referenced objects", "env"),
+	)
+
+	// spec.env/envFromで参照しているSecretが更新される(resourceVersionが変わる)とPod Templateのchecksumが変わること
+	It("Should change the env checksum when the referenced Secret changes", func() {
+		nginxes, err := render.ReadFile(filepath.Join("testdata", "env.yaml"), scheme, "default")
+		Expect(err).NotTo(HaveOccurred())
+
+		checksum := func(resourceVersion string) string {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "upstream-token", Namespace: "default", ResourceVersion: resourceVersion},
+				Data:       map[string][]byte{"token": []byte("a")},
+			}
+			objs, err := newReconciler(scheme).Render(nginxes[0], secret)
+			Expect(err).NotTo(HaveOccurred())
+			deploy, ok := objs[0].(*appsv1.Deployment)
+			Expect(ok).To(BeTrue())
+			return deploy.Spec.Template.Annotations["nginx.my.domain/env-checksum"]
+		}
+
+		Expect(checksum("1")).NotTo(BeEmpty())
+		Expect(checksum("1")).To(Equal(checksum("1")))
+		Expect(checksum("1")).NotTo(Equal(checksum("2")))
+	})
+
+	// upstreamのServiceのselectorとtargetPortからEgressのルールが生成されること
+	It("Should derive egress rules from upstream Services", func() {
+		nginxes, err := render.ReadFile(filepath.Join("testdata", "networkpolicy.yaml"), scheme, "default")
+		Expect(err).NotTo(HaveOccurred())
+
+		backend := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "default"},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "backend"},
+				Ports: []corev1.ServicePort{
+					{Port: 80, TargetPort: intstr.FromString("http")},
+					{Port: 9090, Protocol: corev1.ProtocolTCP},
+				},
+			},
+		}
+		objs, err := newReconciler(scheme).Render(nginxes[0], backend)
+		Expect(err).NotTo(HaveOccurred())
+
+		var policy *networkingv1.NetworkPolicy
+		for _, obj := range objs {
+			if p, ok := obj.(*networkingv1.NetworkPolicy); ok {
+				policy = p
+			}
+		}
+		Expect(policy).NotTo(BeNil())
+		Expect(policy.Spec.Egress).To(HaveLen(3)) // DNS、Service、CIDR
+
+		rule := policy.Spec.Egress[1]
+		Expect(rule.To).To(HaveLen(1))
+		Expect(rule.To[0].PodSelector.MatchLabels).To(Equal(map[string]string{"app": "backend"}))
+		Expect(rule.To[0].NamespaceSelector).To(BeNil())
+		Expect(rule.Ports).To(HaveLen(2))
+		Expect(*rule.Ports[0].Port).To(Equal(intstr.FromString("http")))
+		Expect(*rule.Ports[1].Port).To(Equal(intstr.FromInt(9090)))
+	})
+
+	// metadata.namespaceが未指定の場合は指定したNamespaceを設定し、デフォルト値を埋めること
+	It("Should set the namespace and the builtin defaults", func() {
+		nginxes, err := render.Decode(strings.NewReader(`
+apiVersion: nginx.my.domain/v2
+kind: Nginx
+metadata:
+  name: nginx-1
+`), scheme, "team-a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nginxes).To(HaveLen(1))
+		Expect(nginxes[0].Namespace).To(Equal("team-a"))
+		Expect(nginxes[0].Spec.Image).To(Equal(nginxv2.DefaultImage))
+		Expect(*nginxes[0].Spec.Replicas).To(Equal(int32(1)))
+	})
+
+	// Nginx以外のリソースやNginxを含まない入力はエラーになること
+	It("Should reject manifests without Nginx", func() {
+		_, err := render.Decode(strings.NewReader(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+`), scheme, "default")
+		Expect(err).To(MatchError(ContainSubstring("unsupported kind")))
+
+		_, err = render.Decode(strings.NewReader("---\n"), scheme, "default")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,82 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sharding は複数のControllerでNginxリソースを分担するためのShardを扱う
+package sharding
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// Shard はControllerが担当するShard
+// Countが1以下の場合はShardingせずに全てのNginxリソースを担当する
+type Shard struct {
+	// 担当するShardの番号(0からCount-1まで)
+	ID int
+	// Shardの総数
+	Count int
+}
+
+// Validate はIDがCountの範囲内であることを確認する
+func (s Shard) Validate() error {
+	if s.Count < 1 {
+		return fmt.Errorf("shard count must be at least 1: %d", s.Count)
+	}
+	if s.ID < 0 || s.ID >= s.Count {
+		return fmt.Errorf("shard id must be between 0 and %d: %d", s.Count-1, s.ID)
+	}
+	return nil
+}
+
+// Enabled はShardingするかを返す
+func (s Shard) Enabled() bool {
+	return s.Count > 1
+}
+
+// Of は"namespace/name"のハッシュ値からNginxリソースを担当するShardの番号を返す
+func Of(namespace, name string, count int) int {
+	if count <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(namespace + "/" + name))
+	return int(h.Sum32() % uint32(count))
+}
+
+// Contains はNginxリソースがこのShardの担当かを返す
+func (s Shard) Contains(namespace, name string) bool {
+	return !s.Enabled() || Of(namespace, name, s.Count) == s.ID
+}
+
+// Predicate はこのShardが担当するNginxリソースのイベントのみを通すPredicate
+func (s Shard) Predicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return s.Contains(obj.GetNamespace(), obj.GetName())
+	})
+}
+
+// LeaderElectionID はShardごとのLeader ElectionのIDを返す
+// Shardごとに1つのControllerがLeaderとなるようにShardの番号と総数をIDに含める
+func (s Shard) LeaderElectionID(id string) string {
+	if !s.Enabled() {
+		return id
+	}
+	return fmt.Sprintf("shard-%d-of-%d.%s", s.ID, s.Count, id)
+}
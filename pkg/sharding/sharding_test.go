@@ -0,0 +1,71 @@
+package sharding_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"example.com/nginx-controller/pkg/sharding"
+)
+
+func TestSharding(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "sharding Suite")
+}
+
+var _ = Describe("Shard", func() {
+
+	// IDがCountの範囲外の場合はエラーになること
+	It("Should validate the shard id and count", func() {
+		Expect(sharding.Shard{ID: 0, Count: 1}.Validate()).To(Succeed())
+		Expect(sharding.Shard{ID: 2, Count: 3}.Validate()).To(Succeed())
+		Expect(sharding.Shard{ID: 0, Count: 0}.Validate()).NotTo(Succeed())
+		Expect(sharding.Shard{ID: 3, Count: 3}.Validate()).NotTo(Succeed())
+		Expect(sharding.Shard{ID: -1, Count: 3}.Validate()).NotTo(Succeed())
+	})
+
+	// Shardingしない場合は全てのNginxリソースを担当すること
+	It("Should contain everything when sharding is disabled", func() {
+		s := sharding.Shard{ID: 0, Count: 1}
+		Expect(s.Enabled()).To(BeFalse())
+		Expect(s.Contains("default", "nginx-1")).To(BeTrue())
+		Expect(s.LeaderElectionID("31c31aa8.my.domain")).To(Equal("31c31aa8.my.domain"))
+	})
+
+	// 各Nginxリソースをちょうど1つのShardが担当すること
+	It("Should assign each Nginx to exactly one shard", func() {
+		const count = 3
+		assigned := make([]int, count)
+		for i := 0; i < 100; i++ {
+			name := fmt.Sprintf("nginx-%d", i)
+			owners := 0
+			for id := 0; id < count; id++ {
+				if (sharding.Shard{ID: id, Count: count}).Contains("default", name) {
+					owners++
+					assigned[id]++
+				}
+			}
+			Expect(owners).To(Equal(1))
+		}
+		for _, n := range assigned {
+			Expect(n).To(BeNumerically(">", 0))
+		}
+	})
+
+	// 同じ名前でもNamespaceによって担当するShardが分かれること
+	It("Should hash the namespace and the name", func() {
+		shards := map[int]bool{}
+		for i := 0; i < 20; i++ {
+			shards[sharding.Of(fmt.Sprintf("team-%d", i), "nginx-1", 3)] = true
+		}
+		Expect(shards).To(HaveLen(3))
+	})
+
+	// ShardごとにLeader ElectionのIDが異なること
+	It("Should generate a leader election id per shard", func() {
+		Expect(sharding.Shard{ID: 1, Count: 3}.LeaderElectionID("31c31aa8.my.domain")).To(Equal("shard-1-of-3.31c31aa8.my.domain"))
+	})
+})